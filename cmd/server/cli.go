@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+// runCommand runs the subcommand named by the first argument and reports whether there was one.
+// The subcommands exit with 0 on success and 1 on failure, so they can be used in CI.
+func runCommand(args []string, stdout, stderr io.Writer) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "check-config":
+		return checkConfigCommand(args[1:], stdout, stderr), true
+	case "render":
+		return renderCommand(args[1:], stdout, stderr), true
+	}
+	return 0, false
+}
+
+// checkConfigCommand validates a config file the same way it is validated when it is loaded by the server,
+// including the parsing of its templates and the reading of its secret files.
+func checkConfigCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		configFile = fs.String("config-file", "", "The connectors configuration file to check.")
+		schemaFile = fs.String("schema", "", "The adaptive card schema file. The built-in schema is used if empty.")
+	)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *configFile == "" {
+		fmt.Fprintln(stderr, "the config-file is required")
+		return 1
+	}
+
+	tc, err := parseTeamsConfigFile(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "config check failed: %v\n", err)
+		return 1
+	}
+	if len(tc.Connectors) == 0 {
+		fmt.Fprintf(stderr, "invalid config file %s: no connectors\n", *configFile)
+		return 1
+	}
+	b := newRouteBuilder(log.NewNopLogger(), http.DefaultClient, "", nil, *schemaFile)
+	if _, err := b.build(tc); err != nil {
+		fmt.Fprintf(stderr, "invalid config file %s: %v\n", *configFile, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "config file %s is valid, %d connectors\n", *configFile, len(tc.Connectors))
+	return 0
+}
+
+// renderCommand renders the card of a template for an Alertmanager webhook message
+// and validates it against the adaptive card schema, if it is a card of the webex backend.
+func renderCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		templateFile      = fs.String("template", "", "The card template file. The built-in template is used if empty.")
+		alertFile         = fs.String("alert", "", "The file holding the Alertmanager webhook message in JSON.")
+		schemaFile        = fs.String("schema", "", "The adaptive card schema file. The built-in schema is used if empty.")
+		escapeUnderscores = fs.Bool("escape-underscores", false, "Automatically replace all '_' with '\\_' from texts in the alert.")
+		backendType       = fs.String("type", "webex", "The backend of the template: webex|msteams|slack|webhook. Only webex cards are validated.")
+	)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *alertFile == "" {
+		fmt.Fprintln(stderr, "the alert is required")
+		return 1
+	}
+	backend, err := service.ParseBackend(*backendType)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	b, err := ioutil.ReadFile(*alertFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	var wm webhook.Message
+	if err := json.Unmarshal(b, &wm); err != nil {
+		fmt.Fprintf(stderr, "invalid alert file %s: %v\n", *alertFile, err)
+		return 1
+	}
+	schema, err := card.DefaultSchema()
+	if *schemaFile != "" {
+		schema, err = card.LoadSchema(*schemaFile)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	tmpl, err := builtinTemplate(backend)()
+	if *templateFile != "" {
+		tmpl, err = card.ParseTemplateFile(*templateFile)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	c, err := card.NewTemplatedCardCreator(tmpl, *escapeUnderscores).Convert(context.Background(), wm)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(c), "", "  "); err != nil {
+		fmt.Fprintln(stdout, c)
+		// The body of the webhook backend may be any text.
+		if backend == service.BackendWebhook {
+			return 0
+		}
+		fmt.Fprintf(stderr, "the card is not valid JSON: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, strings.TrimSpace(out.String()))
+
+	if backend != service.BackendWebex {
+		return 0
+	}
+	if err := schema.Validate(c); err != nil {
+		var ve *card.ValidationError
+		if !errors.As(err, &ve) {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintln(stderr, "the card does not match the adaptive card schema:")
+		for _, e := range ve.Errors {
+			fmt.Fprintf(stderr, "  - %s\n", e)
+		}
+		return 1
+	}
+	return 0
+}
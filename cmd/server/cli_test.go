@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_runCommand(t *testing.T) {
+	const alert = "../../pkg/card/testdata/prometheus_fire_request.json"
+
+	tests := []struct {
+		name       string
+		args       func(t *testing.T) []string
+		wantOK     bool
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name: "no command",
+			args: func(t *testing.T) []string { return []string{"-config-file", "config.yml"} },
+		},
+		{
+			name: "valid config",
+			args: func(t *testing.T) []string {
+				return []string{"check-config", "-config-file", writeFile(t, "config.yml", `
+connectors:
+  - request_path: alerts
+    access_token: token
+    room_id: room
+    webhook_url: https://webexapis.com/v1/messages
+`)}
+			},
+			wantOK:     true,
+			wantStdout: "is valid, 1 connectors",
+		},
+		{
+			name: "invalid config",
+			args: func(t *testing.T) []string {
+				return []string{"check-config", "-config-file", writeFile(t, "config.yml", `
+connectors:
+  - request_path: alerts
+    access_token: token
+    webhook_url: https://webexapis.com/v1/messages
+`)}
+			},
+			wantOK:     true,
+			wantCode:   1,
+			wantStderr: "are required for request_path 'alerts'",
+		},
+		{
+			name: "missing config file",
+			args: func(t *testing.T) []string {
+				return []string{"check-config", "-config-file", "does-not-exist.yml"}
+			},
+			wantOK:     true,
+			wantCode:   1,
+			wantStderr: "config check failed",
+		},
+		{
+			name: "missing schema file",
+			args: func(t *testing.T) []string {
+				return []string{"check-config", "-schema", "does-not-exist.json", "-config-file", writeFile(t, "config.yml", `
+connectors:
+  - request_path: alerts
+    access_token: token
+    room_id: room
+    webhook_url: https://webexapis.com/v1/messages
+`)}
+			},
+			wantOK:     true,
+			wantCode:   1,
+			wantStderr: "failed to load schema does-not-exist.json",
+		},
+		{
+			name:       "built-in template",
+			args:       func(t *testing.T) []string { return []string{"render", "-alert", alert} },
+			wantOK:     true,
+			wantStdout: `"type": "AdaptiveCard"`,
+		},
+		{
+			name: "schema violating template",
+			args: func(t *testing.T) []string {
+				return []string{"render", "-alert", alert, "-template", writeFile(t, "card.tmpl", `{{ define "teams.card" }}
+{"type": "AdaptiveCard", "version": "1.2", "body": [{"type": "TextBlock", "size": "huge", "text": "{{ .Status }}"}]}
+{{ end }}`)}
+			},
+			wantOK:     true,
+			wantCode:   1,
+			wantStdout: `"size": "huge"`,
+			wantStderr: "the card does not match the adaptive card schema:\n  - ",
+		},
+		{
+			name: "non-webex template",
+			args: func(t *testing.T) []string {
+				return []string{"render", "-alert", alert, "-type", "slack"}
+			},
+			wantOK:     true,
+			wantStdout: `"attachments": [`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code, ok := runCommand(tt.args(t), &stdout, &stderr)
+			if ok != tt.wantOK || code != tt.wantCode {
+				t.Fatalf("want code %d and ok %v, got %d and %v\nstdout: %s\nstderr: %s",
+					tt.wantCode, tt.wantOK, code, ok, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("want stdout containing %q, got %s", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("want stderr containing %q, got %s", tt.wantStderr, stderr.String())
+			}
+			if tt.wantStderr == "" && stderr.Len() > 0 {
+				t.Errorf("want no stderr, got %s", stderr.String())
+			}
+		})
+	}
+}
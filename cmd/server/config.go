@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"gopkg.in/yaml.v2"
+)
+
+// PromTeamsConfig is the struct representation of the config file.
+type PromTeamsConfig struct {
+	Connectors []Connector `yaml:"connectors"`
+}
+
+// ConnectorWithCustomTemplate .
+type Connector struct {
+	RequestPath       string                 `yaml:"request_path"`
+	AccessToken       Secret                 `yaml:"access_token"`
+	AccessTokenFile   string                 `yaml:"access_token_file"`
+	RoomId            string                 `yaml:"room_id"`
+	RoomIds           []string               `yaml:"room_ids"`
+	PersonIds         []string               `yaml:"person_ids"`
+	PersonEmails      []string               `yaml:"person_emails"`
+	TemplateFile      string                 `yaml:"template_file"`
+	WebhookURL        string                 `yaml:"webhook_url"`
+	EscapeUnderscores bool                   `yaml:"escape_underscores"`
+	Retry             service.RetryPolicy    `yaml:"retry"`
+	FailureMapping    service.FailureMapping `yaml:"failure_mapping"`
+	// Type is the backend receiving the messages: webex (default), msteams, slack or webhook.
+	// The webhook_url of msteams and slack is the incoming webhook of the channel, and the one of webhook
+	// may carry a token as well. It is the credential of the channel, so it is not shown by the /config endpoint.
+	Type service.Backend `yaml:"type"`
+	// Headers are sent with the requests of the webhook type, e.g. an Authorization header.
+	// Their values are not shown by the /config endpoint.
+	Headers map[string]Secret `yaml:"headers"`
+	// Split configures how alert groups producing cards larger than Webex accepts are sent.
+	Split service.SplitPolicy `yaml:"split"`
+	// RequestTemplateFile overrides the built-in template of the Webex request sending the card.
+	RequestTemplateFile string `yaml:"request_template_file"`
+	// CardBuilder builds the card from Go structs instead of the template_file, if set.
+	CardBuilder *card.BuilderConfig `yaml:"card_builder"`
+	// Routes send the alerts to rooms by their labels, alerts matching no route go to the rooms above.
+	Routes []ConnectorRoute `yaml:"routes"`
+	// RecipientLabels and RecipientAnnotations name the alert labels and annotations holding
+	// the emails or IDs of persons receiving the alert as direct message, e.g. owner_email.
+	RecipientLabels      []string `yaml:"recipient_labels"`
+	RecipientAnnotations []string `yaml:"recipient_annotations"`
+	// ThreadTTL enables threading the notifications of an alert group under its first message,
+	// and is the time the thread is kept after the last notification of the group.
+	ThreadTTL time.Duration `yaml:"thread_ttl"`
+	// OnResolve posts the resolved notification in the thread (post), or deletes (delete)
+	// the first message of the group instead. Requires ThreadTTL.
+	OnResolve service.ResolveAction `yaml:"on_resolve"`
+	// DedupWindow drops notifications identical to one sent within the window, disabled if 0.
+	DedupWindow time.Duration `yaml:"dedup_window"`
+	// Digest collects the alerts and sends them as one digest message every interval, if set.
+	Digest *DigestConfig `yaml:"digest"`
+	// Auth authenticates the requests posted by Alertmanager, all requests are accepted if not set.
+	Auth *AuthConfig `yaml:"auth"`
+	// OAuth gets the access tokens of a Webex integration with a refresh token instead of the access_token.
+	OAuth *OAuthConfig `yaml:"oauth"`
+}
+
+// OAuthConfig configures the refresh of the expiring access tokens of a Webex integration.
+type OAuthConfig struct {
+	ClientID         string `yaml:"client_id"`
+	ClientSecret     Secret `yaml:"client_secret"`
+	ClientSecretFile string `yaml:"client_secret_file"`
+	RefreshToken     Secret `yaml:"refresh_token"`
+	RefreshTokenFile string `yaml:"refresh_token_file"`
+	// TokenURL is the OAuth token endpoint, https://webexapis.com/v1/access_token if empty.
+	TokenURL string `yaml:"token_url"`
+	// RefreshBefore is the time before the expiry of the access token when it is refreshed, 1h if 0.
+	RefreshBefore time.Duration `yaml:"refresh_before"`
+}
+
+// AuthConfig configures the authentication of the requests to a connector, exactly one method must be set.
+// The secrets can be read from files, like the http_config of the Alertmanager webhook receiver.
+type AuthConfig struct {
+	BasicAuth       *BasicAuthConfig `yaml:"basic_auth"`
+	BearerToken     Secret           `yaml:"bearer_token"`
+	BearerTokenFile string           `yaml:"bearer_token_file"`
+	HMAC            *HMACConfig      `yaml:"hmac"`
+}
+
+// BasicAuthConfig configures HTTP basic authentication.
+type BasicAuthConfig struct {
+	Username     string `yaml:"username"`
+	Password     Secret `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// HMACConfig configures the HMAC-SHA256 signature of the request body.
+type HMACConfig struct {
+	Secret     Secret `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	// Header holds the signature, X-Signature if empty.
+	Header string `yaml:"header"`
+}
+
+// DigestConfig configures the digest of a connector.
+type DigestConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	// TemplateFile is the template of the digest card. If empty, the built-in digest template
+	// groups the alerts of the digest by alertname and status.
+	TemplateFile string `yaml:"template_file"`
+}
+
+// ConnectorRoute sends the alerts matching all matchers to its rooms.
+type ConnectorRoute struct {
+	// Matchers use the Alertmanager label matcher syntax, e.g. team="payments".
+	Matchers     []string `yaml:"matchers"`
+	RoomIds      []string `yaml:"room_ids"`
+	PersonIds    []string `yaml:"person_ids"`
+	PersonEmails []string `yaml:"person_emails"`
+	// TemplateFile replaces the template of the connector for the matching alerts, if set.
+	TemplateFile string `yaml:"template_file"`
+	// Continue evaluates the following routes as well if the route matched.
+	Continue bool `yaml:"continue"`
+}
+
+// Secret is a config value which is not revealed when the config is shown, e.g. by the /config endpoint.
+type Secret string
+
+const redacted = "<secret>"
+
+// MarshalJSON implements json.Marshaler.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return json.Marshal("")
+	}
+	return json.Marshal(redacted)
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s == "" {
+		return "", nil
+	}
+	return redacted, nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c Connector) MarshalJSON() ([]byte, error) {
+	type plain Connector
+	return json.Marshal(plain(c.shown()))
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (c Connector) MarshalYAML() (interface{}, error) {
+	type plain Connector
+	return plain(c.shown()), nil
+}
+
+// shown returns the connector as it is shown, with the webhook_url redacted unless it is the public Webex API.
+func (c Connector) shown() Connector {
+	if c.Type.WithDefaults() != service.BackendWebex && c.WebhookURL != "" {
+		c.WebhookURL = redacted
+	}
+	return c
+}
+
+func parseTeamsConfigFile(f string) (PromTeamsConfig, error) {
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return PromTeamsConfig{}, err
+	}
+	var tc PromTeamsConfig
+	if err = yaml.Unmarshal(b, &tc); err != nil {
+		return PromTeamsConfig{}, err
+	}
+	if err = expandEnv(&tc); err != nil {
+		return PromTeamsConfig{}, fmt.Errorf("invalid config file %s: %w", f, err)
+	}
+	return tc, nil
+}
+
+// envReference matches ${NAME} references to environment variables.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${NAME} references in the string values of the config with the value of the environment variable.
+// The values are expanded after the config is parsed, so comments are not expanded
+// and the values of the variables are not parsed as YAML.
+// Only this form is expanded, so a $ in label matchers or other values is kept as it is.
+func expandEnv(tc *PromTeamsConfig) error {
+	var missing []string
+	expandStrings(reflect.ValueOf(tc).Elem(), func(s string) string {
+		return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+			name := envReference.FindStringSubmatch(ref)[1]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("undefined environment variables %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expandStrings replaces all exported strings in v with the result of expand,
+// walking through pointers, structs, slices and maps.
+func expandStrings(v reflect.Value, expand func(string) string) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expand(v.String()))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandStrings(v.Elem(), expand)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			expandStrings(v.Field(i), expand)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandStrings(v.Index(i), expand)
+		}
+	case reflect.Map:
+		// Map values cannot be set in place, they are expanded in a copy.
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			expandStrings(e, expand)
+			v.SetMapIndex(k, e)
+		}
+	}
+}
+
+// files returns all files the config depends on, besides the config file itself.
+func (tc PromTeamsConfig) files() []string {
+	var files []string
+	for _, c := range tc.Connectors {
+		switch {
+		case c.Digest != nil:
+			if c.Digest.TemplateFile != "" {
+				files = append(files, c.Digest.TemplateFile)
+			}
+		case c.TemplateFile != "" && c.CardBuilder == nil:
+			files = append(files, c.TemplateFile)
+		}
+		for _, r := range c.Routes {
+			if r.TemplateFile != "" {
+				files = append(files, r.TemplateFile)
+			}
+		}
+		if c.RequestTemplateFile != "" {
+			files = append(files, c.RequestTemplateFile)
+		}
+		if c.AccessTokenFile != "" {
+			files = append(files, c.AccessTokenFile)
+		}
+		if c.Auth != nil {
+			files = append(files, c.Auth.files()...)
+		}
+		if c.OAuth != nil {
+			files = append(files, c.OAuth.files()...)
+		}
+	}
+	return files
+}
+
+// files returns the secret files of the OAuth config.
+func (o OAuthConfig) files() []string {
+	var files []string
+	if o.ClientSecretFile != "" {
+		files = append(files, o.ClientSecretFile)
+	}
+	if o.RefreshTokenFile != "" {
+		files = append(files, o.RefreshTokenFile)
+	}
+	return files
+}
+
+// files returns the secret files of the authentication.
+func (a AuthConfig) files() []string {
+	var files []string
+	if a.BasicAuth != nil && a.BasicAuth.PasswordFile != "" {
+		files = append(files, a.BasicAuth.PasswordFile)
+	}
+	if a.BearerTokenFile != "" {
+		files = append(files, a.BearerTokenFile)
+	}
+	if a.HMAC != nil && a.HMAC.SecretFile != "" {
+		files = append(files, a.HMAC.SecretFile)
+	}
+	return files
+}
+
+// webexSettings returns the names of the settings of the connector which are only supported by the webex backend.
+func (c Connector) webexSettings() []string {
+	var names []string
+	for _, s := range []struct {
+		name string
+		set  bool
+	}{
+		{"access_token", c.AccessToken != "" || c.AccessTokenFile != ""},
+		{"oauth", c.OAuth != nil},
+		{"room_id, room_ids, person_ids or person_emails", len(c.targets()) > 0},
+		{"routes", len(c.Routes) > 0},
+		{"recipient_labels or recipient_annotations", len(c.RecipientLabels) > 0 || len(c.RecipientAnnotations) > 0},
+		{"thread_ttl", c.ThreadTTL != 0},
+		{"on_resolve", c.OnResolve != "" && c.OnResolve != service.ResolvePost},
+		{"split", c.Split != service.SplitPolicy{}},
+		{"request_template_file", c.RequestTemplateFile != ""},
+		{"card_builder", c.CardBuilder != nil},
+	} {
+		if s.set {
+			names = append(names, s.name)
+		}
+	}
+	return names
+}
+
+// headers returns the headers of the requests of the webhook type.
+func (c Connector) headers() http.Header {
+	h := http.Header{}
+	for name, value := range c.Headers {
+		h.Set(name, string(value))
+	}
+	return h
+}
+
+// targets returns the rooms of room_id and room_ids and the persons of person_ids and person_emails without duplicates.
+func (c Connector) targets() []service.Target {
+	return uniqueTargets(
+		service.RoomTargets(append([]string{c.RoomId}, c.RoomIds...)...),
+		service.PersonTargets(c.PersonIds...),
+		service.EmailTargets(c.PersonEmails...),
+	)
+}
+
+// targets returns the rooms and persons of the route without duplicates.
+func (r ConnectorRoute) targets() []service.Target {
+	return uniqueTargets(
+		service.RoomTargets(r.RoomIds...),
+		service.PersonTargets(r.PersonIds...),
+		service.EmailTargets(r.PersonEmails...),
+	)
+}
+
+func uniqueTargets(lists ...[]service.Target) []service.Target {
+	var targets []service.Target
+	seen := map[service.Target]bool{}
+	for _, l := range lists {
+		for _, t := range l {
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+func checkFailureMapping(m service.FailureMapping) error {
+	for name, status := range map[string]int{
+		"client_error_status":    m.ClientErrorStatus,
+		"server_error_status":    m.ServerErrorStatus,
+		"transport_error_status": m.TransportErrorStatus,
+	} {
+		if status != 0 && (status < 200 || status > 599) {
+			return fmt.Errorf("%s %d is not a valid HTTP status code", name, status)
+		}
+	}
+	return nil
+}
+
+// headerName matches the valid names of HTTP headers.
+var headerName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+func checkHeaders(headers map[string]Secret) error {
+	for name, value := range headers {
+		if !headerName.MatchString(name) {
+			return fmt.Errorf("%q is not a valid header name", name)
+		}
+		if strings.ContainsAny(string(value), "\r\n") {
+			return fmt.Errorf("the value of header %s must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+func checkOnResolve(c Connector) error {
+	switch c.OnResolve {
+	case "", service.ResolvePost:
+		return nil
+	case service.ResolveDelete:
+		if c.ThreadTTL <= 0 {
+			return fmt.Errorf("%s requires a thread_ttl", c.OnResolve)
+		}
+		return nil
+	case "edit":
+		return errors.New("edit is not supported, since Webex does not allow editing messages with cards")
+	default:
+		return fmt.Errorf("unknown value %s, valid values are post, delete", c.OnResolve)
+	}
+}
+
+func checkAuth(a AuthConfig) error {
+	var methods int
+	if a.BasicAuth != nil {
+		methods++
+		if a.BasicAuth.Username == "" {
+			return errors.New("basic_auth requires a username")
+		}
+		if err := checkSecret("basic_auth password", a.BasicAuth.Password, a.BasicAuth.PasswordFile); err != nil {
+			return err
+		}
+	}
+	if a.BearerToken != "" || a.BearerTokenFile != "" {
+		methods++
+		if err := checkSecret("bearer_token", a.BearerToken, a.BearerTokenFile); err != nil {
+			return err
+		}
+	}
+	if a.HMAC != nil {
+		methods++
+		if err := checkSecret("hmac secret", a.HMAC.Secret, a.HMAC.SecretFile); err != nil {
+			return err
+		}
+	}
+	if methods != 1 {
+		return errors.New("exactly one of basic_auth, bearer_token, bearer_token_file or hmac is required")
+	}
+	return nil
+}
+
+func checkOAuth(o OAuthConfig) error {
+	if o.ClientID == "" {
+		return errors.New("the client_id is required")
+	}
+	if err := checkSecret("client_secret", o.ClientSecret, o.ClientSecretFile); err != nil {
+		return err
+	}
+	return checkSecret("refresh_token", o.RefreshToken, o.RefreshTokenFile)
+}
+
+// checkSecret checks that a secret is either given inline or by a file.
+func checkSecret(name string, secret Secret, file string) error {
+	if (secret == "") == (file == "") {
+		return fmt.Errorf("either the %s or its file is required", name)
+	}
+	return nil
+}
+
+// readSecret returns the secret, or the content of the file without surrounding whitespace if it is set.
+func readSecret(secret Secret, file string) (string, error) {
+	if file == "" {
+		return string(secret), nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("the secret file %s is empty", file)
+	}
+	return s, nil
+}
+
+// queueName derives the name of the delivery queue directory from a request path.
+func queueName(requestPath string) string {
+	name := strings.ReplaceAll(strings.Trim(requestPath, "/"), "/", "_")
+	if name == "" {
+		return "root"
+	}
+	return name
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"gopkg.in/yaml.v2"
+)
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	prev, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// writeFile writes the content to a file in a temporary directory of the test and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(f, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func Test_parseTeamsConfigFile_env(t *testing.T) {
+	setenv(t, "TEST_WEBEX_TOKEN", `to"ken # with: yaml`)
+	setenv(t, "TEST_WEBEX_ROOM", "room")
+	setenv(t, "TEST_EVENTS_TOKEN", "events")
+
+	tests := []struct {
+		name    string
+		config  string
+		want    Connector
+		wantErr string
+	}{
+		{
+			name: "expanded values",
+			config: `
+connectors:
+  - request_path: alerts
+    access_token: ${TEST_WEBEX_TOKEN}
+    room_id: "${TEST_WEBEX_ROOM}"
+    routes:
+      - matchers: ['team=~"$payments"']
+        room_ids:
+          - room-${TEST_WEBEX_ROOM}
+    headers:
+      Authorization: Bearer ${TEST_EVENTS_TOKEN}
+`,
+			want: Connector{
+				RequestPath: "alerts",
+				AccessToken: `to"ken # with: yaml`,
+				RoomId:      "room",
+				Routes:      []ConnectorRoute{{Matchers: []string{`team=~"$payments"`}, RoomIds: []string{"room-room"}}},
+				Headers:     map[string]Secret{"Authorization": "Bearer events"},
+			},
+		},
+		{
+			name: "references in comments",
+			config: `
+connectors:
+  # access_token: ${TEST_UNDEFINED}
+  - request_path: alerts
+`,
+			want: Connector{RequestPath: "alerts"},
+		},
+		{
+			name: "undefined variables",
+			config: `
+connectors:
+  - request_path: alerts
+    access_token: ${TEST_UNDEFINED}
+    room_id: ${TEST_UNDEFINED_ROOM}
+`,
+			wantErr: "undefined environment variables TEST_UNDEFINED, TEST_UNDEFINED_ROOM",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc, err := parseTeamsConfigFile(writeFile(t, "config.yml", tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("want error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tc.Connectors) != 1 {
+				t.Fatalf("want 1 connector, got %d", len(tc.Connectors))
+			}
+			if !reflect.DeepEqual(tc.Connectors[0], tt.want) {
+				t.Errorf("want %#v, got %#v", tt.want, tc.Connectors[0])
+			}
+		})
+	}
+}
+
+// formats are the formats the config is shown in.
+var formats = []struct {
+	name      string
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte, interface{}) error
+}{
+	{"json", json.Marshal, json.Unmarshal},
+	{"yaml", yaml.Marshal, yaml.Unmarshal},
+}
+
+func TestSecret(t *testing.T) {
+	tc := PromTeamsConfig{Connectors: []Connector{{
+		RequestPath: "alerts",
+		AccessToken: "webex-token",
+		Headers:     map[string]Secret{"Authorization": "Bearer events-token"},
+		Auth:        &AuthConfig{BasicAuth: &BasicAuthConfig{Username: "alertmanager", Password: "password"}},
+		OAuth:       &OAuthConfig{ClientID: "client"},
+	}}}
+
+	for _, f := range formats {
+		b, err := f.marshal(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Secret only redacts when marshalling, so the shown values are read back as they are.
+		var shown PromTeamsConfig
+		if err := f.unmarshal(b, &shown); err != nil {
+			t.Fatal(err)
+		}
+		c := shown.Connectors[0]
+		secrets := map[string]Secret{
+			"access_token":        c.AccessToken,
+			"headers":             c.Headers["Authorization"],
+			"basic_auth":          c.Auth.BasicAuth.Password,
+			"empty client_secret": c.OAuth.ClientSecret,
+		}
+		want := map[string]Secret{
+			"access_token":        redacted,
+			"headers":             redacted,
+			"basic_auth":          redacted,
+			"empty client_secret": "",
+		}
+		for k, v := range secrets {
+			if v != want[k] {
+				t.Errorf("%s: want %s shown as %q, got %q", f.name, k, want[k], v)
+			}
+		}
+		if c.RequestPath != "alerts" || c.Auth.BasicAuth.Username != "alertmanager" || c.OAuth.ClientID != "client" {
+			t.Errorf("%s: want the other values shown, got %s", f.name, b)
+		}
+	}
+}
+
+func TestConnector_webhookURLShown(t *testing.T) {
+	tests := []struct {
+		backend service.Backend
+		url     string
+		want    string
+	}{
+		{"", "https://webexapis.com/v1/messages", "https://webexapis.com/v1/messages"},
+		{service.BackendWebex, "https://webex.example.com/v1/messages", "https://webex.example.com/v1/messages"},
+		{service.BackendMSTeams, "https://example.webhook.office.com/webhookb2/XXXX", redacted},
+		{service.BackendSlack, "https://hooks.slack.com/services/T000/B000/XXXX", redacted},
+		{service.BackendWebhook, "https://events.example.com/alerts?token=XXXX", redacted},
+		{service.BackendSlack, "", ""},
+	}
+	for _, tt := range tests {
+		tc := PromTeamsConfig{Connectors: []Connector{{RequestPath: "alerts", Type: tt.backend, WebhookURL: tt.url}}}
+		for _, f := range formats {
+			b, err := f.marshal(tc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var shown PromTeamsConfig
+			if err := f.unmarshal(b, &shown); err != nil {
+				t.Fatal(err)
+			}
+			if got := shown.Connectors[0].WebhookURL; got != tt.want {
+				t.Errorf("%s: want the webhook_url of type %q shown as %q, got %q", f.name, tt.backend, tt.want, got)
+			}
+			if shown.Connectors[0].Type != tt.backend || tc.Connectors[0].WebhookURL != tt.url {
+				t.Errorf("%s: want only the shown webhook_url redacted, got %s", f.name, b)
+			}
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/infonova/prometheus-webexteams/pkg/service"
 	"github.com/infonova/prometheus-webexteams/pkg/transport"
 	"github.com/infonova/prometheus-webexteams/pkg/version"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -31,37 +30,13 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/run"
 	"github.com/peterbourgon/ff"
-	"gopkg.in/yaml.v2"
 )
 
-// PromTeamsConfig is the struct representation of the config file.
-type PromTeamsConfig struct {
-	Connectors []Connector `yaml:"connectors"`
-}
-
-// ConnectorWithCustomTemplate .
-type Connector struct {
-	RequestPath       string `yaml:"request_path"`
-	AccessToken       string `yaml:"access_token"`
-	RoomId            string `yaml:"room_id"`
-	TemplateFile      string `yaml:"template_file"`
-	WebhookURL        string `yaml:"webhook_url"`
-	EscapeUnderscores bool   `yaml:"escape_underscores"`
-}
-
-func parseTeamsConfigFile(f string) (PromTeamsConfig, error) {
-	b, err := ioutil.ReadFile(f)
-	if err != nil {
-		return PromTeamsConfig{}, err
-	}
-	var tc PromTeamsConfig
-	if err = yaml.Unmarshal(b, &tc); err != nil {
-		return PromTeamsConfig{}, err
+func main() { //nolint: funlen
+	if code, ok := runCommand(os.Args[1:], os.Stdout, os.Stderr); ok {
+		os.Exit(code)
 	}
-	return tc, nil
-}
 
-func main() { //nolint: funlen
 	var (
 		fs                            = flag.NewFlagSet("prometheus-webexteams", flag.ExitOnError)
 		promVersion                   = fs.Bool("version", false, "Print the version")
@@ -70,16 +45,36 @@ func main() { //nolint: funlen
 		jaegerTrace                   = fs.Bool("jaeger-trace", false, "Send traces to Jaeger.")
 		jaegerAgentAddr               = fs.String("jaeger-agent", "localhost:6831", "Jaeger agent endpoint")
 		httpAddr                      = fs.String("http-addr", ":2000", "HTTP listen address.")
+		httpTLSCertFile               = fs.String("http-tls-cert-file", "", "The certificate file of the HTTP listener. TLS is disabled if empty.")
+		httpTLSKeyFile                = fs.String("http-tls-key-file", "", "The private key file of the HTTP listener certificate.")
+		httpTLSClientCAFile           = fs.String("http-tls-client-ca-file", "", "The CA file verifying client certificates. Client certificates are not required if empty.")
+		httpTLSReloadInterval         = fs.Duration("http-tls-reload-interval", 30*time.Second, "The interval for checking the certificate, key and client CA files for changes. Disabled if 0.")
 		requestURI                    = fs.String("request-uri", "alertmanager", "The default request URI path where Prometheus will post to.")
-		teamsWebhookURL               = fs.String("teams-webhook-url", "https://webexapis.com/v1/messages", "The default Webex Teams webhook connector.")
+		teamsWebhookURL               = fs.String("teams-webhook-url", service.DefaultWebhookURL, "The default Webex Teams webhook connector.")
 		teamsAccessToken              = fs.String("teams-access-token", "", "The access token to authorize the requests.")
+		teamsAccessTokenFile          = fs.String("teams-access-token-file", "", "The file holding the access token, read instead of teams-access-token if set.")
 		teamsRoomId                   = fs.String("teams-room-id", "", "The room specifies the target room of the messages.")
-		templateFile                  = fs.String("template-file", "resources/default-message-card.tmpl", "The default Webex Teams Message Card template file.")
+		templateFile                  = fs.String("template-file", "", "The default Webex Teams Message Card template file. The built-in template is used if empty.")
+		cardSchemaFile                = fs.String("card-schema-file", "", "The adaptive card schema file validating the cards. The built-in schema is used if empty.")
 		escapeUnderscores             = fs.Bool("escape-underscores", false, "Automatically replace all '_' with '\\_' from texts in the alert.")
 		configFile                    = fs.String("config-file", "", "The connectors configuration file.")
+		configReloadInterval          = fs.Duration("config-reload-interval", 10*time.Second, "The interval for checking the config and template files for changes. Disabled if 0.")
 		httpClientIdleConnTimeout     = fs.Duration("idle-conn-timeout", 90*time.Second, "The HTTP client idle connection timeout duration.")
 		httpClientTLSHandshakeTimeout = fs.Duration("tls-handshake-timeout", 30*time.Second, "The HTTP client TLS handshake timeout.")
 		httpClientMaxIdleConn         = fs.Int("max-idle-conns", 100, "The HTTP client maximum number of idle connections")
+		retryMaxAttempts              = fs.Int("retry-max-attempts", service.DefaultRetryPolicy.MaxAttempts, "The maximum number of attempts to deliver a message to Webex Teams.")
+		retryInitialInterval          = fs.Duration("retry-initial-interval", service.DefaultRetryPolicy.InitialInterval, "The wait time before the first retry of a failed Webex Teams request.")
+		queueDir                      = fs.String("queue-dir", "", "The directory where undelivered messages are kept until Webex Teams is available again. Disabled if empty.")
+		queueReplayInterval           = fs.Duration("queue-replay-interval", 30*time.Second, "The interval for retrying the delivery of queued messages.")
+		dedupWindow                   = fs.Duration("dedup-window", 0, "The window in which notifications identical to a sent one are dropped. Disabled if 0.")
+		threadTTL                     = fs.Duration("thread-ttl", 0, "The time the notifications of an alert group are threaded under its first message after its last notification. Disabled if 0.")
+		onResolve                     = fs.String("on-resolve", "post", "What happens with the first message of a threaded alert group when it resolves: post|delete.")
+		rateLimitTokenRate            = fs.Float64("rate-limit-token-rate", 0, "The maximum number of messages per second sent with one access token. Unlimited if 0.")
+		rateLimitTokenBurst           = fs.Int("rate-limit-token-burst", 10, "The number of messages that may be sent at once with one access token.")
+		rateLimitRoomRate             = fs.Float64("rate-limit-room-rate", 0, "The maximum number of messages per second sent to one room or person. Unlimited if 0.")
+		rateLimitRoomBurst            = fs.Int("rate-limit-room-burst", 5, "The number of messages that may be sent at once to one room or person.")
+		rateLimitMaxWait              = fs.Duration("rate-limit-max-wait", 30*time.Second, "The maximum time a message waits for the rate limiter before it is queued or rejected.")
+		retryMaxInterval              = fs.Duration("retry-max-interval", service.DefaultRetryPolicy.MaxInterval, "The maximum wait time between two retries of a failed Webex Teams request.")
 	)
 
 	if err := ff.Parse(fs, os.Args[1:], ff.WithEnvVarNoPrefix()); err != nil {
@@ -135,51 +130,32 @@ func main() { //nolint: funlen
 		)
 	}
 
-	// Prepare the Teams config.
-	var (
-		tc  PromTeamsConfig
-		err error
-	)
-
-	// Parse the config file if defined.
-	if *configFile != "" {
-		// parse config file
-		tc, err = parseTeamsConfigFile(*configFile)
-		if err != nil {
-			fmt.Fprint(os.Stderr, err.Error())
-			os.Exit(1)
+	// Prepare the Teams config, either from the config file or from flags.
+	loadConfig := func() (PromTeamsConfig, error) {
+		if *configFile != "" {
+			return parseTeamsConfigFile(*configFile)
 		}
-	} else {
-		// create a connector from flags
-		tc.Connectors = append(
-			tc.Connectors,
-			Connector{
-				RequestPath:       *requestURI,
-				WebhookURL:        *teamsWebhookURL,
-				AccessToken:       *teamsAccessToken,
-				RoomId:            *teamsRoomId,
-				TemplateFile:      *templateFile,
-				EscapeUnderscores: *escapeUnderscores,
+		return PromTeamsConfig{
+			Connectors: []Connector{
+				{
+					RequestPath:       *requestURI,
+					WebhookURL:        *teamsWebhookURL,
+					AccessToken:       Secret(*teamsAccessToken),
+					AccessTokenFile:   *teamsAccessTokenFile,
+					RoomId:            *teamsRoomId,
+					TemplateFile:      *templateFile,
+					EscapeUnderscores: *escapeUnderscores,
+					ThreadTTL:         *threadTTL,
+					DedupWindow:       *dedupWindow,
+					OnResolve:         service.ResolveAction(*onResolve),
+					Retry: service.RetryPolicy{
+						MaxAttempts:     *retryMaxAttempts,
+						InitialInterval: *retryInitialInterval,
+						MaxInterval:     *retryMaxInterval,
+					},
+				},
 			},
-		)
-	}
-
-	// Templated card defaultConverter setup.
-	var defaultConverter card.Converter
-	{
-		tmpl, err := card.ParseTemplateFile(*templateFile)
-		if err != nil {
-			logger.Log("err", err)
-		}
-		defaultConverter = card.NewTemplatedCardCreator(tmpl, *escapeUnderscores)
-		defaultConverter = card.NewCreatorLoggingMiddleware(
-			log.With(
-				logger,
-				"template_file", *templateFile,
-				"escaped_underscores", *escapeUnderscores,
-			),
-			defaultConverter,
-		)
+		}, nil
 	}
 
 	// Teams HTTP client setup.
@@ -199,60 +175,6 @@ func main() { //nolint: funlen
 		},
 	}
 
-	var routes []transport.Route
-	for _, c := range tc.Connectors {
-
-		// check connector configuration
-		if len(c.RequestPath) == 0 {
-			logger.Log("err", "one of the 'templated_connectors' is missing a 'request_path'")
-			os.Exit(1)
-		}
-		if len(c.WebhookURL) == 0 {
-			logger.Log("err", fmt.Sprintf("The teams-webhook-url is required for request_path '%s'", c.RequestPath))
-			os.Exit(1)
-		}
-		if len(c.AccessToken) == 0 {
-			logger.Log("err", fmt.Sprintf("The teams-access-token is required for request_path '%s'", c.RequestPath))
-			os.Exit(1)
-		}
-		if len(c.RoomId) == 0 {
-			logger.Log("err", fmt.Sprintf("The teams-room-id is required for request_path '%s'", c.RequestPath))
-			os.Exit(1)
-		}
-		if len(c.TemplateFile) == 0 {
-			logger.Log("err", fmt.Sprintf("The template_file is required for request_path '%s'", c.RequestPath))
-			os.Exit(1)
-		}
-
-		var converter card.Converter
-		tmpl, err := card.ParseTemplateFile(c.TemplateFile)
-		if err != nil {
-			logger.Log("err", err)
-			os.Exit(1)
-		}
-
-		converter = card.NewTemplatedCardCreator(tmpl, c.EscapeUnderscores)
-		converter = card.NewCreatorLoggingMiddleware(
-			log.With(
-				logger,
-				"template_file", c.TemplateFile,
-				"escaped_underscores", c.EscapeUnderscores,
-			),
-			converter,
-		)
-
-		var r transport.Route
-		r.RequestPath = c.RequestPath
-		r.Service = service.NewSimpleService(converter, httpClient, c.WebhookURL, c.AccessToken, c.RoomId)
-		r.Service = service.NewLoggingService(logger, r.Service)
-		routes = append(routes, r)
-	}
-
-	if err := checkDuplicateRequestPath(routes); err != nil {
-		logger.Log("err", err)
-		os.Exit(1)
-	}
-
 	pe, err := ocprometheus.NewExporter(
 		ocprometheus.Options{
 			Registry: stdprometheus.DefaultRegisterer.(*stdprometheus.Registry),
@@ -267,19 +189,59 @@ func main() { //nolint: funlen
 		os.Exit(1)
 	}
 
+	// Rate limiter, shared by all routes.
+	var limiter *service.RateLimiter
+	if *rateLimitTokenRate > 0 || *rateLimitRoomRate > 0 {
+		limiter = service.NewRateLimiter(
+			service.RateLimit{Rate: *rateLimitTokenRate, Burst: *rateLimitTokenBurst},
+			service.RateLimit{Rate: *rateLimitRoomRate, Burst: *rateLimitRoomBurst},
+			*rateLimitMaxWait,
+		)
+	}
+
+	// Routes setup, rebuilt on every config reload.
+	router := transport.NewRouter(logger)
+	rl := newReloader(
+		log.With(logger, "component", "reloader"),
+		*configFile,
+		loadConfig,
+		newRouteBuilder(logger, httpClient, *queueDir, limiter, *cardSchemaFile),
+		router,
+	)
+	if err := rl.reload(); err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
 	// Prometheus webex teams HTTP handler setup.
 	var handler *echo.Echo
 	{
 		// Main app.
-		handler = transport.NewServer(logger, routes...)
+		handler = transport.NewRouterServer(logger, router)
 		// Prometheus metrics.
 		handler.GET("/metrics", echo.WrapHandler(pe))
 		// Pprof.
 		handler.GET("/debug/pprof/*", echo.WrapHandler(http.DefaultServeMux))
 		// Config.
 		handler.GET("/config", func(c echo.Context) error {
-			return c.JSON(200, tc.Connectors)
+			return c.JSON(200, rl.Config().Connectors)
 		})
+		// Config reload.
+		rl.register(handler)
+	}
+
+	// TLS setup of the HTTP listener.
+	var tr *tlsReloader
+	if *httpTLSCertFile != "" || *httpTLSKeyFile != "" || *httpTLSClientCAFile != "" {
+		if *httpTLSCertFile == "" || *httpTLSKeyFile == "" {
+			logger.Log("err", "both http-tls-cert-file and http-tls-key-file are required for TLS")
+			os.Exit(1)
+		}
+		tr, err = newTLSReloader(log.With(logger, "component", "tls"), *httpTLSCertFile, *httpTLSKeyFile, *httpTLSClientCAFile)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
 	}
 
 	var g run.Group
@@ -292,11 +254,17 @@ func main() { //nolint: funlen
 			func() error {
 				logger.Log(
 					"listen_http_addr", *httpAddr,
+					"tls", tr != nil,
+					"client_auth", *httpTLSClientCAFile != "",
 					"version", version.VERSION,
 					"commit", version.COMMIT,
 					"branch", version.BRANCH,
 					"build_date", version.BUILDDATE,
 				)
+				if tr != nil {
+					srv.TLSConfig = tr.TLSConfig()
+					return srv.ListenAndServeTLS("", "")
+				}
 				return srv.ListenAndServe()
 			},
 			func(error) {
@@ -308,6 +276,51 @@ func main() { //nolint: funlen
 			},
 		)
 	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return rl.run(ctx, *configReloadInterval)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	if tr != nil && *httpTLSReloadInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return tr.run(ctx, *httpTLSReloadInterval)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	if *queueDir != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				logger.Log("message", "delivery queue enabled", "queue_dir", *queueDir)
+				return service.RunReplayer(ctx, log.With(logger, "component", "queue"), *queueReplayInterval, rl.Replayers)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(
+			func() error {
+				return service.RunFlusher(ctx, log.With(logger, "component", "digest"), time.Second, rl.Flushers)
+			},
+			func(error) {
+				cancel()
+			},
+		)
+	}
 	{
 		g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
 	}
@@ -322,6 +335,95 @@ func ocviews() []*view.View {
 		ochttp.StatusCode, ochttp.Method, ochttp.Path,
 	}
 	return []*view.View{
+		// Config reload metrics.
+		{
+			Name:        "webexteams/config_last_reload_successful",
+			Measure:     measureReloadSuccess,
+			Aggregation: view.LastValue(),
+			Description: "Whether the last configuration reload attempt was successful",
+		},
+		{
+			Name:        "webexteams/config_last_reload_success_timestamp_seconds",
+			Measure:     measureReloadSuccessTimestamp,
+			Aggregation: view.LastValue(),
+			Description: "Timestamp of the last successful configuration reload",
+		},
+		// Delivery metrics.
+		{
+			Name:        "webexteams/post_attempts",
+			Measure:     service.MeasurePostAttempts,
+			Aggregation: view.Distribution(1, 2, 3, 4, 5, 6, 8, 10),
+			Description: "Distribution of attempts needed to deliver a message, by outcome and backend",
+			TagKeys:     []tag.Key{service.KeyOutcome, service.KeyBackend},
+		},
+		{
+			Name:        "webexteams/post_attempts_total",
+			Measure:     service.MeasurePostAttempts,
+			Aggregation: view.Sum(),
+			Description: "Total number of attempts made to deliver messages, by outcome and backend",
+			TagKeys:     []tag.Key{service.KeyOutcome, service.KeyBackend},
+		},
+		{
+			Name:        "webexteams/queue_length",
+			Measure:     service.MeasureQueueLength,
+			Aggregation: view.LastValue(),
+			Description: "Number of messages waiting in the delivery queue, by queue",
+			TagKeys:     []tag.Key{service.KeyQueue},
+		},
+		{
+			Name:        "webexteams/queue_dropped_total",
+			Measure:     service.MeasureQueueDropped,
+			Aggregation: view.Count(),
+			Description: "Number of queued messages dropped because Webex Teams rejected them, by queue",
+			TagKeys:     []tag.Key{service.KeyQueue},
+		},
+		{
+			Name:        "webexteams/notifications_suppressed_total",
+			Measure:     service.MeasureSuppressed,
+			Aggregation: view.Count(),
+			Description: "Number of duplicate notifications suppressed",
+		},
+		{
+			Name:        "webexteams/rate_limit_wait_milliseconds",
+			Measure:     service.MeasureRateLimitWait,
+			Aggregation: view.Distribution(10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000),
+			Description: "Time messages waited for the rate limiter, by limit",
+			TagKeys:     []tag.Key{service.KeyLimit},
+		},
+		{
+			Name:        "webexteams/rate_limit_exceeded_total",
+			Measure:     service.MeasureRateLimitExceeded,
+			Aggregation: view.Count(),
+			Description: "Number of messages rejected by the rate limiter because they would have waited too long, by limit",
+			TagKeys:     []tag.Key{service.KeyLimit},
+		},
+		{
+			Name:        "webexteams/rate_limit_buckets",
+			Measure:     service.MeasureRateLimitBuckets,
+			Aggregation: view.LastValue(),
+			Description: "Number of rate limiter token buckets that are not full",
+		},
+		{
+			Name:        "webexteams/token_refreshes_total",
+			Measure:     service.MeasureTokenRefreshes,
+			Aggregation: view.Count(),
+			Description: "Number of OAuth access token refreshes, by outcome",
+			TagKeys:     []tag.Key{service.KeyOutcome},
+		},
+		{
+			Name:        "webexteams/invalid_cards_total",
+			Measure:     card.MeasureInvalidCards,
+			Aggregation: view.Count(),
+			Description: "Number of cards not matching the adaptive card schema, which were replaced by the fallback card",
+		},
+		// Inbound request metrics.
+		{
+			Name:        "webexteams/auth_failures_total",
+			Measure:     transport.MeasureAuthFailures,
+			Aggregation: view.Count(),
+			Description: "Number of requests rejected because they were not authenticated, by request path and reason",
+			TagKeys:     []tag.Key{transport.KeyRequestPath, transport.KeyReason},
+		},
 		// HTTP client metrics.
 		{
 			Name:        "http/client/sent_bytes",
@@ -382,14 +484,3 @@ func ocviews() []*view.View {
 		},
 	}
 }
-
-func checkDuplicateRequestPath(routes []transport.Route) error {
-	added := map[string]bool{}
-	for _, r := range routes {
-		if _, ok := added[r.RequestPath]; ok {
-			return fmt.Errorf("found duplicate use of request path '%s'", r.RequestPath)
-		}
-		added[r.RequestPath] = true
-	}
-	return nil
-}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"github.com/infonova/prometheus-webexteams/pkg/transport"
+	"github.com/labstack/echo/v4"
+	"go.opencensus.io/stats"
+)
+
+var (
+	measureReloadSuccess = stats.Int64(
+		"webexteams/config_last_reload_successful",
+		"Whether the last configuration reload attempt was successful",
+		stats.UnitDimensionless,
+	)
+	measureReloadSuccessTimestamp = stats.Int64(
+		"webexteams/config_last_reload_success_timestamp_seconds",
+		"Timestamp of the last successful configuration reload",
+		stats.UnitSeconds,
+	)
+)
+
+// reloadStatus is the outcome of the last configuration reload.
+type reloadStatus struct {
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+	LastReload  time.Time `json:"last_reload"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// reloader rebuilds the routes of the server from the config.
+// The new routes are only applied if the whole config is valid,
+// otherwise the server keeps serving the old routes.
+type reloader struct {
+	logger     log.Logger
+	configFile string
+	load       func() (PromTeamsConfig, error)
+	builder    *routeBuilder
+	router     *transport.Router
+
+	mu        sync.Mutex
+	config    PromTeamsConfig
+	replayers []service.Replayer
+	flushers  []service.Flusher
+	files     []string
+	checksum  string
+	status    reloadStatus
+}
+
+func newReloader(
+	logger log.Logger,
+	configFile string,
+	load func() (PromTeamsConfig, error),
+	builder *routeBuilder,
+	router *transport.Router,
+) *reloader {
+	return &reloader{
+		logger:     logger,
+		configFile: configFile,
+		load:       load,
+		builder:    builder,
+		router:     router,
+	}
+}
+
+// reload loads the config and replaces the routes of the router.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.apply()
+
+	now := time.Now()
+	r.status.LastReload = now
+	r.status.Success = err == nil
+	r.status.Error = ""
+	if err != nil {
+		r.status.Error = err.Error()
+		stats.Record(context.Background(), measureReloadSuccess.M(0))
+		return err
+	}
+	r.status.LastSuccess = now
+	stats.Record(
+		context.Background(),
+		measureReloadSuccess.M(1),
+		measureReloadSuccessTimestamp.M(now.Unix()),
+	)
+	return nil
+}
+
+func (r *reloader) apply() error {
+	tc, err := r.load()
+	if err != nil {
+		r.watch(r.files)
+		return err
+	}
+	r.watch(tc.files())
+
+	rs, err := r.builder.build(tc)
+	if err != nil {
+		return err
+	}
+	r.router.Set(rs.routes...)
+	r.config = tc
+	r.replayers = rs.replayers
+	r.flushers = rs.flushers
+	return nil
+}
+
+// watch remembers the checksum of the config file and the given files,
+// so a failed reload is not repeated until one of them changes again.
+func (r *reloader) watch(files []string) {
+	r.files = files
+	r.checksum = checksumFiles(append([]string{r.configFile}, files...))
+}
+
+// changed reports whether the config file or one of the files it references changed since the last reload.
+func (r *reloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return checksumFiles(append([]string{r.configFile}, r.files...)) != r.checksum
+}
+
+// Config returns the currently applied config.
+func (r *reloader) Config() PromTeamsConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.config
+}
+
+// Replayers returns the replayers of the currently applied routes.
+func (r *reloader) Replayers() []service.Replayer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.replayers
+}
+
+// Flushers returns the flushers of the currently applied routes.
+func (r *reloader) Flushers() []service.Flusher {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.flushers
+}
+
+// Status returns the outcome of the last reload.
+func (r *reloader) Status() reloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// run reloads the config on SIGHUP and, if interval is positive,
+// whenever a change of the config or template files is detected.
+func (r *reloader) run(ctx context.Context, interval time.Duration) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			_ = r.reloadAndLog("sighup")
+		case <-tick:
+			if r.changed() {
+				_ = r.reloadAndLog("file_change")
+			}
+		}
+	}
+}
+
+func (r *reloader) reloadAndLog(trigger string) error {
+	if err := r.reload(); err != nil {
+		r.logger.Log("message", "config reload failed, keeping the previous config", "trigger", trigger, "err", err)
+		return err
+	}
+	r.logger.Log("message", "config reloaded", "trigger", trigger)
+	return nil
+}
+
+// register adds the /-/reload endpoint to the server.
+// GET returns the outcome of the last reload, POST reloads the config and returns its outcome,
+// with status 500 if the reload failed.
+func (r *reloader) register(e *echo.Echo) {
+	e.GET("/-/reload", func(c echo.Context) error {
+		return c.JSON(200, r.Status())
+	})
+	e.POST("/-/reload", func(c echo.Context) error {
+		if err := r.reloadAndLog("http"); err != nil {
+			return c.JSON(500, r.Status())
+		}
+		return c.JSON(200, r.Status())
+	})
+}
+
+// checksumFiles returns a checksum over the content of the given files.
+// Missing files are part of the checksum, so their creation is detected too.
+func checksumFiles(files []string) string {
+	h := sha256.New()
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		sum := sha256.Sum256(b)
+		h.Write([]byte(f))
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/transport"
+)
+
+// webexServer is a fake Webex API counting the messages per room.
+type webexServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	rooms map[string]int
+}
+
+func newWebexServer(t *testing.T) *webexServer {
+	s := &webexServer{rooms: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RoomID string `json:"roomId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.rooms[req.RoomID]++
+		fmt.Fprintf(w, `{"id": "m%d"}`, s.rooms[req.RoomID])
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webexServer) messages(room string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rooms[room]
+}
+
+// connectorConfig returns the config of a connector sending to the room of the fake Webex API.
+func connectorConfig(webexURL, requestPath, room string) string {
+	return fmt.Sprintf(`
+  - request_path: %s
+    access_token: token
+    room_id: %s
+    webhook_url: %s
+    thread_ttl: 1h
+`, requestPath, room, webexURL)
+}
+
+func Test_reloader(t *testing.T) {
+	webex := newWebexServer(t)
+	configFile := writeFile(t, "config.yml", "connectors:"+connectorConfig(webex.URL, "alerts", "room-a"))
+
+	router := transport.NewRouter(log.NewNopLogger())
+	rl := newReloader(
+		log.NewNopLogger(),
+		configFile,
+		func() (PromTeamsConfig, error) { return parseTeamsConfigFile(configFile) },
+		newRouteBuilder(log.NewNopLogger(), http.DefaultClient, "", nil, ""),
+		router,
+	)
+	srv := transport.NewRouterServer(log.NewNopLogger(), router)
+	rl.register(srv)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	post := func(path string) int {
+		t.Helper()
+		b, err := ioutil.ReadFile("../../pkg/card/testdata/prometheus_fire_request.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(string(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	reload := func(method string) (int, reloadStatus) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+"/-/reload", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status reloadStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, status
+	}
+	write := func(config string) {
+		t.Helper()
+		if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rl.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code := post("/alerts"); code != http.StatusOK || webex.messages("room-a") != 1 {
+		t.Fatalf("want the alert sent to room-a, got status %d and %d messages", code, webex.messages("room-a"))
+	}
+	if rl.changed() {
+		t.Error("want no change before the config file is written")
+	}
+
+	// A config with an invalid connector is rejected as a whole, the old routes are kept.
+	write("connectors:" + connectorConfig(webex.URL, "alerts", "room-b") + connectorConfig(webex.URL, "", "room-c"))
+	if !rl.changed() {
+		t.Fatal("want a change after the config file is written")
+	}
+	code, status := reload(http.MethodPost)
+	if code != http.StatusInternalServerError || status.Success || !strings.Contains(status.Error, "request_path") {
+		t.Errorf("want the failed reload reported, got status %d and %+v", code, status)
+	}
+	if rl.changed() {
+		t.Error("want the failed reload not to be repeated until the config file changes again")
+	}
+	if code := post("/alerts"); code != http.StatusOK || webex.messages("room-a") != 2 || webex.messages("room-b") != 0 {
+		t.Errorf("want the alert sent to room-a by the old route, got status %d", code)
+	}
+	if _, status := reload(http.MethodGet); status.Success || status.LastSuccess.IsZero() {
+		t.Errorf("want GET to return the failed reload after the last success, got %+v", status)
+	}
+
+	// A valid config replaces the routes.
+	write("connectors:" + connectorConfig(webex.URL, "alerts", "room-b") + connectorConfig(webex.URL, "other", "room-c"))
+	if code, status := reload(http.MethodPost); code != http.StatusOK || !status.Success || status.Error != "" {
+		t.Errorf("want a successful reload, got status %d and %+v", code, status)
+	}
+	if post("/alerts"); webex.messages("room-b") != 1 {
+		t.Errorf("want the alert sent to room-b by the new route, got %d messages", webex.messages("room-b"))
+	}
+	if post("/other"); webex.messages("room-c") != 1 {
+		t.Errorf("want the alert sent to room-c by the new route, got %d messages", webex.messages("room-c"))
+	}
+	if got := rl.Config().Connectors; len(got) != 2 {
+		t.Errorf("want the config of the 2 new connectors, got %d", len(got))
+	}
+}
+
+func Test_routeBuilder_build_rejected(t *testing.T) {
+	config := func(threadTTL time.Duration, requestPaths ...string) PromTeamsConfig {
+		var tc PromTeamsConfig
+		for _, p := range requestPaths {
+			tc.Connectors = append(tc.Connectors, Connector{
+				RequestPath: p,
+				AccessToken: "token",
+				RoomId:      "room",
+				WebhookURL:  "https://webexapis.com/v1/messages",
+				ThreadTTL:   threadTTL,
+				DedupWindow: time.Minute,
+			})
+		}
+		return tc
+	}
+
+	b := newRouteBuilder(log.NewNopLogger(), http.DefaultClient, "", nil, "")
+	if _, err := b.build(config(time.Hour, "alerts")); err != nil {
+		t.Fatal(err)
+	}
+	threads, dedup := b.state.threads["/alerts"], b.state.dedups["/alerts"]
+
+	// The rejected config changes the thread TTL of the served route, which would replace its thread store.
+	if _, err := b.build(config(2*time.Hour, "alerts", "")); err == nil {
+		t.Fatal("want the config with a connector without request_path rejected")
+	}
+	if b.state.threads["/alerts"] != threads || b.state.dedups["/alerts"] != dedup {
+		t.Error("want the state of the served route kept after a rejected config")
+	}
+	if len(b.state.threads) != 1 {
+		t.Errorf("want no state of the rejected routes, got threads %v", b.state.threads)
+	}
+
+	// A valid config with the same settings keeps using the state.
+	if _, err := b.build(config(time.Hour, "alerts")); err != nil {
+		t.Fatal(err)
+	}
+	if b.state.threads["/alerts"] != threads || b.state.dedups["/alerts"] != dedup {
+		t.Error("want the state of the route kept after a reload")
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"github.com/infonova/prometheus-webexteams/pkg/transport"
+)
+
+// routeBuilder creates the routes serving the connectors of a config.
+type routeBuilder struct {
+	logger     log.Logger
+	httpClient *http.Client
+	queueDir   string
+	limiter    *service.RateLimiter
+	// schemaFile is the adaptive card schema validating the cards, the built-in schema is used if empty.
+	schemaFile string
+	// state is shared by all routes built for the same request path, so it is kept when the config is reloaded.
+	state routeState
+}
+
+// routeState holds the state of the routes, keyed by their request path.
+type routeState struct {
+	// queues hold the queued messages, keyed by the directory of the queue.
+	queues  map[string]*service.FileQueue
+	threads map[string]*service.ThreadStore
+	digests map[string]*service.DigestBatch
+	dedups  map[string]*service.DedupCache
+	// oauthTokens are shared, so the access tokens are not refreshed on every reload.
+	oauthTokens map[string]*service.RefreshTokenSource
+}
+
+func newRouteState() routeState {
+	return routeState{
+		queues:      map[string]*service.FileQueue{},
+		threads:     map[string]*service.ThreadStore{},
+		digests:     map[string]*service.DigestBatch{},
+		dedups:      map[string]*service.DedupCache{},
+		oauthTokens: map[string]*service.RefreshTokenSource{},
+	}
+}
+
+// clone returns a copy of the state, which can be changed without changing s.
+func (s routeState) clone() routeState {
+	c := newRouteState()
+	for k, v := range s.queues {
+		c.queues[k] = v
+	}
+	for k, v := range s.threads {
+		c.threads[k] = v
+	}
+	for k, v := range s.digests {
+		c.digests[k] = v
+	}
+	for k, v := range s.dedups {
+		c.dedups[k] = v
+	}
+	for k, v := range s.oauthTokens {
+		c.oauthTokens[k] = v
+	}
+	return c
+}
+
+func newRouteBuilder(logger log.Logger, httpClient *http.Client, queueDir string, limiter *service.RateLimiter, schemaFile string) *routeBuilder {
+	return &routeBuilder{
+		logger:     logger,
+		httpClient: httpClient,
+		queueDir:   queueDir,
+		limiter:    limiter,
+		schemaFile: schemaFile,
+		state:      newRouteState(),
+	}
+}
+
+// routeSet holds the routes built from a config together with the background tasks of their services.
+type routeSet struct {
+	routes []transport.Route
+	// replayers deliver the queued messages of the routes.
+	replayers []service.Replayer
+	// flushers send the digests of the routes.
+	flushers []service.Flusher
+}
+
+// build validates the connectors of the config and creates their routes.
+// The routes are built with a copy of the shared state, which replaces the state only if the whole config is valid,
+// so a rejected config does not replace e.g. the threads or token sources of the routes still served.
+func (b *routeBuilder) build(tc PromTeamsConfig) (routeSet, error) {
+	state := b.state
+	b.state = state.clone()
+	rs, err := b.buildRoutes(tc)
+	if err != nil {
+		b.state = state
+		return routeSet{}, err
+	}
+	return rs, nil
+}
+
+func (b *routeBuilder) buildRoutes(tc PromTeamsConfig) (routeSet, error) {
+	var rs routeSet
+	for _, c := range tc.Connectors {
+		if err := checkConnector(c); err != nil {
+			return routeSet{}, err
+		}
+
+		converter, err := b.converter(c)
+		if err != nil {
+			return routeSet{}, err
+		}
+
+		auth, err := authenticator(c)
+		if err != nil {
+			return routeSet{}, err
+		}
+
+		var r transport.Route
+		r.RequestPath = c.RequestPath
+		r.Auth = auth
+		switch backend := c.Type.WithDefaults(); backend {
+		case service.BackendWebex:
+			queue, err := b.queue(c.RequestPath)
+			if err != nil {
+				return routeSet{}, err
+			}
+			if r.Service, err = b.webexService(c, converter, queue); err != nil {
+				return routeSet{}, err
+			}
+			if queue != nil {
+				rs.replayers = append(rs.replayers, r.Service.(service.Replayer))
+			}
+		case service.BackendWebhook:
+			r.Service = service.NewWebhookService(converter, b.httpClient, service.WebhookOptions{
+				URL:      c.WebhookURL,
+				Headers:  c.headers(),
+				Retry:    c.Retry,
+				Failures: c.FailureMapping,
+			})
+		default:
+			r.Service = service.NewIncomingWebhookService(converter, b.httpClient, service.IncomingWebhookOptions{
+				Backend:    backend,
+				WebhookURL: c.WebhookURL,
+				Retry:      c.Retry,
+				Failures:   c.FailureMapping,
+			})
+		}
+		if c.Digest != nil {
+			digest := service.NewDigestService(c.Digest.Interval, b.digestBatch(c.RequestPath), r.Service)
+			rs.flushers = append(rs.flushers, digest)
+			r.Service = digest
+		}
+		if c.DedupWindow > 0 {
+			r.Service = service.NewDedupService(c.DedupWindow, b.dedupCache(c.RequestPath), r.Service)
+		}
+		r.Service = service.NewLoggingService(b.logger, r.Service)
+		rs.routes = append(rs.routes, r)
+	}
+
+	if err := checkDuplicateRequestPath(rs.routes); err != nil {
+		return routeSet{}, err
+	}
+	return rs, nil
+}
+
+// webexService creates the service sending the cards of a connector to Webex.
+func (b *routeBuilder) webexService(c Connector, converter card.Converter, queue *service.FileQueue) (service.Service, error) {
+	rules, err := b.rules(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := b.tokenSource(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var request *service.RequestTemplate
+	if c.RequestTemplateFile != "" {
+		if request, err = service.ParseRequestTemplateFile(c.RequestTemplateFile); err != nil {
+			return nil, fmt.Errorf("invalid request_template_file for request_path '%s': %w", c.RequestPath, err)
+		}
+	}
+
+	return service.NewSimpleService(converter, b.httpClient, service.Options{
+		WebhookURL:           c.WebhookURL,
+		Tokens:               tokens,
+		Targets:              c.targets(),
+		Rules:                rules,
+		RecipientLabels:      c.RecipientLabels,
+		RecipientAnnotations: c.RecipientAnnotations,
+		Retry:                c.Retry,
+		Failures:             c.FailureMapping,
+		Queue:                queue,
+		Threads:              b.threadStore(c),
+		OnResolve:            c.OnResolve,
+		Split:                c.Split,
+		Limiter:              b.limiter,
+		RequestTemplate:      request,
+	}), nil
+}
+
+// converter creates the card converter of a connector.
+func (b *routeBuilder) converter(c Connector) (card.Converter, error) {
+	backend := c.Type.WithDefaults()
+	if c.Digest != nil {
+		return b.templateConverter(backend, c.Digest.TemplateFile, card.DigestTemplate, c.EscapeUnderscores)
+	}
+	if c.CardBuilder != nil {
+		converter, err := card.NewCardBuilder(*c.CardBuilder, c.EscapeUnderscores)
+		if err != nil {
+			return nil, fmt.Errorf("invalid card_builder for request_path '%s': %w", c.RequestPath, err)
+		}
+		return b.middlewares(
+			backend,
+			log.With(
+				b.logger,
+				"card_builder", true,
+				"escaped_underscores", c.EscapeUnderscores,
+			),
+			converter,
+		)
+	}
+
+	return b.templateConverter(backend, c.TemplateFile, builtinTemplate(backend), c.EscapeUnderscores)
+}
+
+// builtinTemplate returns the built-in card template of a backend.
+func builtinTemplate(backend service.Backend) func() (*card.Template, error) {
+	switch backend {
+	case service.BackendMSTeams:
+		return card.MSTeamsTemplate
+	case service.BackendSlack:
+		return card.SlackTemplate
+	case service.BackendWebhook:
+		return card.WebhookTemplate
+	}
+	return card.DefaultTemplate
+}
+
+// templateConverter creates a converter rendering the template of the file,
+// or the built-in template if the file is empty.
+func (b *routeBuilder) templateConverter(
+	backend service.Backend,
+	templateFile string,
+	builtin func() (*card.Template, error),
+	escapeUnderscores bool,
+) (card.Converter, error) {
+	var (
+		tmpl *card.Template
+		err  error
+	)
+	if templateFile == "" {
+		tmpl, err = builtin()
+	} else {
+		tmpl, err = card.ParseTemplateFile(templateFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	converter := card.NewTemplatedCardCreator(tmpl, escapeUnderscores)
+	return b.middlewares(
+		backend,
+		log.With(
+			b.logger,
+			"template_file", templateFile,
+			"escaped_underscores", escapeUnderscores,
+		),
+		converter,
+	)
+}
+
+// middlewares wraps the converter with the schema validation and logging.
+// Only the adaptive cards of the webex backend are validated.
+func (b *routeBuilder) middlewares(backend service.Backend, logger log.Logger, converter card.Converter) (card.Converter, error) {
+	if backend != service.BackendWebex {
+		return card.NewCreatorLoggingMiddleware(logger, converter), nil
+	}
+	schema, err := card.DefaultSchema()
+	if b.schemaFile != "" {
+		schema, err = card.LoadSchema(b.schemaFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	converter = card.NewValidatingMiddleware(logger, schema, converter)
+	return card.NewCreatorLoggingMiddleware(logger, converter), nil
+}
+
+// rules creates the routing rules of a connector.
+func (b *routeBuilder) rules(c Connector) ([]service.RoutingRule, error) {
+	var rules []service.RoutingRule
+	for i, r := range c.Routes {
+		matchers, err := service.ParseMatchers(r.Matchers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %d for request_path '%s': %w", i, c.RequestPath, err)
+		}
+		rule := service.RoutingRule{Matchers: matchers, Targets: r.targets(), Continue: r.Continue}
+		if r.TemplateFile != "" {
+			if rule.Converter, err = b.templateConverter(service.BackendWebex, r.TemplateFile, card.DefaultTemplate, c.EscapeUnderscores); err != nil {
+				return nil, fmt.Errorf("invalid route %d for request_path '%s': %w", i, c.RequestPath, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// authenticator creates the authenticator of a connector, or nil if its requests are not authenticated.
+// The secret files are read on every config reload.
+func authenticator(c Connector) (transport.Authenticator, error) {
+	a := c.Auth
+	if a == nil {
+		return nil, nil
+	}
+	var auth transport.Authenticator
+	var err error
+	switch {
+	case a.BasicAuth != nil:
+		var password string
+		password, err = readSecret(a.BasicAuth.Password, a.BasicAuth.PasswordFile)
+		auth = transport.BasicAuth{Username: a.BasicAuth.Username, Password: password}
+	case a.HMAC != nil:
+		var secret string
+		secret, err = readSecret(a.HMAC.Secret, a.HMAC.SecretFile)
+		auth = transport.HMACAuth{Secret: secret, Header: a.HMAC.Header}
+	default:
+		var token string
+		token, err = readSecret(a.BearerToken, a.BearerTokenFile)
+		auth = transport.BearerAuth{Token: token}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth for request_path '%s': %w", c.RequestPath, err)
+	}
+	return auth, nil
+}
+
+// queue returns the delivery queue of a request path, or nil if queueing is disabled.
+func (b *routeBuilder) queue(requestPath string) (*service.FileQueue, error) {
+	if b.queueDir == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(b.queueDir, queueName(requestPath))
+	if q, ok := b.state.queues[dir]; ok {
+		return q, nil
+	}
+	q, err := service.NewFileQueue(dir)
+	if err != nil {
+		return nil, err
+	}
+	b.state.queues[dir] = q
+	return q, nil
+}
+
+// digestBatch returns the digest batch of a request path.
+func (b *routeBuilder) digestBatch(requestPath string) *service.DigestBatch {
+	key := transport.NormalizePath(requestPath)
+	if db, ok := b.state.digests[key]; ok {
+		return db
+	}
+	db := service.NewDigestBatch()
+	b.state.digests[key] = db
+	return db
+}
+
+// dedupCache returns the dedup cache of a request path.
+func (b *routeBuilder) dedupCache(requestPath string) *service.DedupCache {
+	key := transport.NormalizePath(requestPath)
+	if dc, ok := b.state.dedups[key]; ok {
+		return dc
+	}
+	dc := service.NewDedupCache()
+	b.state.dedups[key] = dc
+	return dc
+}
+
+// tokenSource returns the source of the access tokens of a connector.
+// The secret files are read on every config reload.
+func (b *routeBuilder) tokenSource(c Connector) (service.TokenSource, error) {
+	if c.OAuth == nil {
+		token, err := readSecret(c.AccessToken, c.AccessTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access_token_file for request_path '%s': %w", c.RequestPath, err)
+		}
+		return service.StaticToken(token), nil
+	}
+
+	clientSecret, err := readSecret(c.OAuth.ClientSecret, c.OAuth.ClientSecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oauth for request_path '%s': %w", c.RequestPath, err)
+	}
+	refreshToken, err := readSecret(c.OAuth.RefreshToken, c.OAuth.RefreshTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oauth for request_path '%s': %w", c.RequestPath, err)
+	}
+	oc := service.OAuthConfig{
+		ClientID:      c.OAuth.ClientID,
+		ClientSecret:  clientSecret,
+		RefreshToken:  refreshToken,
+		TokenURL:      c.OAuth.TokenURL,
+		RefreshBefore: c.OAuth.RefreshBefore,
+	}
+	key := transport.NormalizePath(c.RequestPath)
+	if ts, ok := b.state.oauthTokens[key]; ok && ts.Config() == oc.WithDefaults() {
+		return ts, nil
+	}
+	ts := service.NewRefreshTokenSource(b.httpClient, oc)
+	b.state.oauthTokens[key] = ts
+	return ts, nil
+}
+
+// threadStore returns the thread store of a connector, or nil if threading is disabled.
+func (b *routeBuilder) threadStore(c Connector) *service.ThreadStore {
+	if c.ThreadTTL <= 0 {
+		return nil
+	}
+	key := transport.NormalizePath(c.RequestPath)
+	if ts, ok := b.state.threads[key]; ok && ts.TTL() == c.ThreadTTL {
+		return ts
+	}
+	ts := service.NewThreadStore(c.ThreadTTL)
+	b.state.threads[key] = ts
+	return ts
+}
+
+func checkConnector(c Connector) error {
+	if len(c.RequestPath) == 0 {
+		return errors.New("one of the 'templated_connectors' is missing a 'request_path'")
+	}
+	if len(c.WebhookURL) == 0 {
+		return fmt.Errorf("the teams-webhook-url is required for request_path '%s'", c.RequestPath)
+	}
+	backend, err := service.ParseBackend(string(c.Type))
+	if err != nil {
+		return fmt.Errorf("invalid type for request_path '%s': %v", c.RequestPath, err)
+	}
+	if backend == service.BackendWebex {
+		if err := checkWebexConnector(c); err != nil {
+			return err
+		}
+	} else {
+		if names := c.webexSettings(); len(names) > 0 {
+			return fmt.Errorf("%s is only supported by the webex type for request_path '%s'", names[0], c.RequestPath)
+		}
+		if c.Digest != nil && c.Digest.TemplateFile == "" {
+			return fmt.Errorf("the digest template_file is required by the %s type for request_path '%s'", backend, c.RequestPath)
+		}
+	}
+	if len(c.Headers) > 0 && backend != service.BackendWebhook {
+		return fmt.Errorf("headers are only supported by the webhook type for request_path '%s'", c.RequestPath)
+	}
+	if err := checkHeaders(c.Headers); err != nil {
+		return fmt.Errorf("invalid headers for request_path '%s': %v", c.RequestPath, err)
+	}
+	if err := checkFailureMapping(c.FailureMapping); err != nil {
+		return fmt.Errorf("invalid failure_mapping for request_path '%s': %v", c.RequestPath, err)
+	}
+	if c.Digest != nil && c.Digest.Interval <= 0 {
+		return fmt.Errorf("the digest interval is required for request_path '%s'", c.RequestPath)
+	}
+	if c.Auth != nil {
+		if err := checkAuth(*c.Auth); err != nil {
+			return fmt.Errorf("invalid auth for request_path '%s': %v", c.RequestPath, err)
+		}
+	}
+	return nil
+}
+
+// checkWebexConnector checks the settings of a connector of the webex type.
+func checkWebexConnector(c Connector) error {
+	if c.OAuth != nil {
+		if len(c.AccessToken) > 0 || len(c.AccessTokenFile) > 0 {
+			return fmt.Errorf("the access_token is not allowed together with oauth for request_path '%s'", c.RequestPath)
+		}
+		if err := checkOAuth(*c.OAuth); err != nil {
+			return fmt.Errorf("invalid oauth for request_path '%s': %v", c.RequestPath, err)
+		}
+	} else {
+		if len(c.AccessToken) == 0 && len(c.AccessTokenFile) == 0 {
+			return fmt.Errorf("the teams-access-token, access_token_file or oauth is required for request_path '%s'", c.RequestPath)
+		}
+		if len(c.AccessToken) > 0 && len(c.AccessTokenFile) > 0 {
+			return fmt.Errorf("only one of access_token and access_token_file is allowed for request_path '%s'", c.RequestPath)
+		}
+	}
+	if len(c.targets()) == 0 && len(c.Routes) == 0 && len(c.RecipientLabels) == 0 && len(c.RecipientAnnotations) == 0 {
+		return fmt.Errorf(
+			"the teams-room-id, room_ids, person_ids, person_emails, routes or recipients are required for request_path '%s'",
+			c.RequestPath,
+		)
+	}
+	for i, r := range c.Routes {
+		if len(r.targets()) == 0 {
+			return fmt.Errorf("route %d of request_path '%s' has no room_ids, person_ids or person_emails", i, c.RequestPath)
+		}
+	}
+	if err := c.Split.Validate(); err != nil {
+		return fmt.Errorf("invalid split for request_path '%s': %v", c.RequestPath, err)
+	}
+	if err := checkOnResolve(c); err != nil {
+		return fmt.Errorf("invalid on_resolve for request_path '%s': %v", c.RequestPath, err)
+	}
+	return nil
+}
+
+func checkDuplicateRequestPath(routes []transport.Route) error {
+	added := map[string]bool{}
+	for _, r := range routes {
+		p := transport.NormalizePath(r.RequestPath)
+		if _, ok := added[p]; ok {
+			return fmt.Errorf("found duplicate use of request path '%s'", r.RequestPath)
+		}
+		added[p] = true
+	}
+	return nil
+}
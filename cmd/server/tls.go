@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// tlsReloader holds the serving certificate and the client CA of the HTTP listener.
+// They are reloaded when their files change, so rotated certificates are used without a restart.
+type tlsReloader struct {
+	logger       log.Logger
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	config atomic.Value // *tls.Config
+
+	mu       sync.Mutex
+	checksum string
+}
+
+func newTLSReloader(logger log.Logger, certFile, keyFile, clientCAFile string) (*tlsReloader, error) {
+	r := &tlsReloader{
+		logger:       logger,
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the config of the listener, which uses the latest loaded certificate for every connection.
+// GetCertificate is set besides GetConfigForClient, since http.Server.ServeTLS without certificate files
+// ignores GetConfigForClient in older Go versions and fails to open the empty file names.
+func (r *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &r.config.Load().(*tls.Config).Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// reload loads the certificate and client CA, the previous ones are kept if they cannot be loaded.
+func (r *tlsReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The checksum is taken first, so files changing while they are loaded are loaded again.
+	// It is kept if loading fails too, so a failed reload is not repeated until the files change again,
+	// e.g. when the key of a rotated certificate is written after the certificate.
+	r.checksum = checksumFiles(r.files())
+	cfg, err := r.load()
+	if err != nil {
+		return err
+	}
+	r.config.Store(cfg)
+	return nil
+}
+
+func (r *tlsReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if r.clientCAFile == "" {
+		return cfg, nil
+	}
+
+	b, err := ioutil.ReadFile(r.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("the TLS client CA file %s contains no PEM encoded certificate", r.clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+func (r *tlsReloader) files() []string {
+	return []string{r.certFile, r.keyFile, r.clientCAFile}
+}
+
+// changed reports whether one of the files changed since the last reload.
+func (r *tlsReloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return checksumFiles(r.files()) != r.checksum
+}
+
+// run reloads the certificate whenever a change of its files is detected, checking them every interval.
+func (r *tlsReloader) run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				r.logger.Log("message", "TLS certificate reload failed, keeping the previous certificate", "err", err)
+				continue
+			}
+			r.logger.Log("message", "TLS certificate reloaded")
+		}
+	}
+}
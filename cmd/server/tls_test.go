@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// testCert is a self-signed certificate for 127.0.0.1.
+type testCert struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, serial int64) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "prometheus-webexteams"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCert{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	if err := ioutil.WriteFile(certFile, c.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, c.keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves the reloader's certificate the way main does, with ServeTLS and no certificate files.
+func serveTLS(t *testing.T, tr *tlsReloader) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: tr.TLSConfig(),
+		ErrorLog:  stdlog.New(ioutil.Discard, "", 0),
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-errc; err != http.ErrServerClosed {
+			t.Errorf("ServeTLS failed: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+// handshake connects to addr and returns the certificate of the server.
+func handshake(addr string, roots *x509.CertPool, clientCerts ...tls.Certificate) (*x509.Certificate, error) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, Certificates: clientCerts})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// The server verifies the client certificate after the client finished the handshake,
+	// a rejection is only reported by the next read.
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func Test_tlsReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	first, second := newTestCert(t, 1), newTestCert(t, 2)
+	first.write(t, certFile, keyFile)
+
+	tr, err := newTLSReloader(log.NewNopLogger(), certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, tr)
+	roots := x509.NewCertPool()
+	roots.AddCert(first.cert)
+	roots.AddCert(second.cert)
+
+	wantSerial := func(name string, want int64) {
+		t.Helper()
+		got, err := handshake(addr, roots)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.SerialNumber.Int64() != want {
+			t.Errorf("%s: want certificate %d, got %d", name, want, got.SerialNumber)
+		}
+	}
+	wantSerial("initial certificate", 1)
+
+	if tr.changed() {
+		t.Error("want no change before the files are written")
+	}
+	second.write(t, certFile, keyFile)
+	if !tr.changed() {
+		t.Fatal("want a change after the files are written")
+	}
+	if err := tr.reload(); err != nil {
+		t.Fatal(err)
+	}
+	wantSerial("rotated certificate", 2)
+
+	// Older Go versions of ServeTLS only use GetCertificate, which must return the reloaded certificate too.
+	cert, err := tr.TLSConfig().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil || leaf.SerialNumber.Int64() != 2 {
+		t.Errorf("want GetCertificate to return certificate 2, got %v (%v)", leaf, err)
+	}
+
+	// A key not matching the certificate is rejected, the previous certificate is kept.
+	if err := ioutil.WriteFile(keyFile, first.keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.reload(); err == nil {
+		t.Error("want an error for a mismatching key")
+	}
+	if tr.changed() {
+		t.Error("want a failed reload not to be repeated until the files change again")
+	}
+	wantSerial("failed reload", 2)
+}
+
+func Test_tlsReloader_clientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	server, client := newTestCert(t, 1), newTestCert(t, 2)
+	server.write(t, certFile, keyFile)
+	if err := ioutil.WriteFile(caFile, client.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := newTLSReloader(log.NewNopLogger(), certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, tr)
+	roots := x509.NewCertPool()
+	roots.AddCert(server.cert)
+
+	if _, err := handshake(addr, roots); err == nil {
+		t.Error("want a connection without client certificate to be rejected")
+	}
+	clientCert, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(addr, roots, clientCert); err != nil {
+		t.Errorf("want a connection with client certificate to be accepted, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(caFile, []byte("no certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTLSReloader(log.NewNopLogger(), certFile, keyFile, caFile); err == nil {
+		t.Error("want an error for a client CA file without certificate")
+	}
+}
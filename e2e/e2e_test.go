@@ -12,6 +12,10 @@ import (
 	"testing"
 
 	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"github.com/infonova/prometheus-webexteams/pkg/testutils"
+	"github.com/infonova/prometheus-webexteams/pkg/transport"
 )
 
 var update = flag.Bool("update", false, "update .golden files")
@@ -22,7 +26,7 @@ type alert struct {
 }
 
 func TestServer(t *testing.T) {
-	tmpl, err := card.ParseTemplateFile("../default-message-card.tmpl")
+	tmpl, err := card.DefaultTemplate()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -70,7 +74,7 @@ func TestServer(t *testing.T) {
 					Service: service.NewLoggingService(
 						logger,
 						service.NewSimpleService(
-							c, http.DefaultClient, testWebhookURL,
+							c, http.DefaultClient, service.Options{WebhookURL: testWebhookURL, AccessToken: "token", Targets: service.RoomTargets("room")},
 						),
 					),
 				},
@@ -78,7 +82,7 @@ func TestServer(t *testing.T) {
 			[]alert{
 				{
 					requestPath:   "/alertmanager",
-					promAlertFile: "../pkg/card/testdata/prom_post_request.json",
+					promAlertFile: "../pkg/card/testdata/prometheus_fire_request.json",
 				},
 			},
 		},
@@ -121,23 +125,21 @@ func TestServer(t *testing.T) {
 					t.Fatalf("want '%d', got '%d'", 200, resp.StatusCode)
 				}
 
-				var prs []service.PostResponse
-				if err := json.NewDecoder(resp.Body).Decode(&prs); err != nil {
+				var pr service.PostResponse
+				if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
 					t.Fatal(err)
 				}
 				if isIntegrationTest {
-					testutils.CompareToGoldenFile(t, prs, t.Name()+"/integration_resp.json", *update)
+					testutils.CompareToGoldenFile(t, pr, t.Name()+"/integration_resp.json", *update)
 					return
 				}
 
 				// because webhook url port dynamically changes
-				for i := range prs {
-					if prs[i].WebhookURL == "" {
-						t.Fatal("webhook url should not be empty")
-					}
-					prs[i].WebhookURL = ""
+				if pr.WebhookURL == "" {
+					t.Fatal("webhook url should not be empty")
 				}
-				testutils.CompareToGoldenFile(t, prs, t.Name()+"/resp.json", *update)
+				pr.WebhookURL = ""
+				testutils.CompareToGoldenFile(t, pr, t.Name()+"/resp.json", *update)
 			}
 		})
 	}
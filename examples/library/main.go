@@ -0,0 +1,108 @@
+// Command library shows how to send Alertmanager webhook messages to Webex from Go code,
+// without the server, its config file or any template files.
+//
+//	WEBEX_ACCESS_TOKEN=... WEBEX_ROOM_ID=... go run ./examples/library -alert pkg/card/testdata/prometheus_fire_request.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+// cardTemplate is a minimal card template using the custom severityIcon function.
+// The texts are quoted with toJson, so quotes and newlines in the alerts do not break the card.
+const cardTemplate = `{{ define "teams.card" }}
+{
+  "$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+  "version": "1.2",
+  "type": "AdaptiveCard",
+  "body": [
+    {{- range $i, $alert := .Alerts }}
+    {{- if $i }},{{ end }}
+    {
+      "type": "TextBlock",
+      "text": {{ printf "%s %s: %s" (severityIcon $alert.Labels.severity) $alert.Labels.alertname $alert.Annotations.message | toJson }},
+      "wrap": true
+    }
+    {{- end }}
+  ]
+}
+{{ end }}`
+
+func main() {
+	alertFile := flag.String("alert", "", "The file holding the Alertmanager webhook message in JSON.")
+	dryRun := flag.Bool("dry-run", false, "Print the card instead of sending it.")
+	flag.Parse()
+
+	if err := run(*alertFile, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(alertFile string, dryRun bool) error {
+	b, err := ioutil.ReadFile(alertFile)
+	if err != nil {
+		return err
+	}
+	var wm webhook.Message
+	if err := json.Unmarshal(b, &wm); err != nil {
+		return err
+	}
+
+	// The card converter renders the template with the custom function,
+	// and replaces cards not matching the built-in adaptive card schema.
+	schema, err := card.DefaultSchema()
+	if err != nil {
+		return err
+	}
+	converter, err := card.New(card.Options{
+		Template: cardTemplate,
+		Funcs: template.FuncMap{
+			"severityIcon": func(severity string) string {
+				if strings.EqualFold(severity, "critical") {
+					return "🔥"
+				}
+				return "⚠️"
+			},
+		},
+		Schema: schema,
+	})
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		c, err := converter.Convert(context.Background(), wm)
+		if err != nil {
+			return err
+		}
+		fmt.Println(c)
+		return nil
+	}
+
+	// The client posts the card to the room with the access token of a bot.
+	client, err := service.NewClient(converter, nil, service.Options{
+		AccessToken: os.Getenv("WEBEX_ACCESS_TOKEN"),
+		Targets:     service.RoomTargets(os.Getenv("WEBEX_ROOM_ID")),
+	})
+	if err != nil {
+		return err
+	}
+	pr, err := client.Post(context.Background(), wm)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("sent message %s to %s\n", pr.MessageID, pr.RoomID)
+	return nil
+}
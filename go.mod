@@ -26,4 +26,4 @@ require (
 	k8s.io/helm v2.16.6+incompatible
 )
 
-go 1.14
+go 1.16
@@ -0,0 +1,101 @@
+package card
+
+// Typed representation of the adaptive card elements supported by Webex Teams.
+// See http://adaptivecards.io/explorer/ for the documentation of the elements.
+
+// AdaptiveCardSchema is the JSON schema reference of an adaptive card.
+const AdaptiveCardSchema = "http://adaptivecards.io/schemas/adaptive-card.json"
+
+// AdaptiveCard is the root element of an adaptive card.
+type AdaptiveCard struct {
+	Schema  string    `json:"$schema"`
+	Version string    `json:"version"`
+	Type    string    `json:"type"`
+	Body    []Element `json:"body"`
+}
+
+// Element is a card element which can be placed in the body of a card or in a container.
+type Element interface {
+	element()
+}
+
+// TextBlock displays text.
+type TextBlock struct {
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	Size      string `json:"size,omitempty"`
+	Weight    string `json:"weight,omitempty"`
+	Color     string `json:"color,omitempty"`
+	Wrap      bool   `json:"wrap,omitempty"`
+	Separator bool   `json:"separator,omitempty"`
+	Spacing   string `json:"spacing,omitempty"`
+}
+
+// Container groups elements together.
+type Container struct {
+	Type      string    `json:"type"`
+	Items     []Element `json:"items"`
+	Style     string    `json:"style,omitempty"`
+	Separator bool      `json:"separator,omitempty"`
+	Spacing   string    `json:"spacing,omitempty"`
+}
+
+// FactSet displays a list of name/value pairs.
+type FactSet struct {
+	Type  string `json:"type"`
+	Facts []Fact `json:"facts"`
+}
+
+// Fact is a name/value pair of a FactSet.
+type Fact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// ActionSet displays a set of actions.
+type ActionSet struct {
+	Type    string          `json:"type"`
+	Actions []ActionOpenURL `json:"actions"`
+}
+
+// ActionOpenURL opens an URL when invoked.
+type ActionOpenURL struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+func (TextBlock) element() {}
+func (Container) element() {}
+func (FactSet) element()   {}
+func (ActionSet) element() {}
+
+// NewAdaptiveCard creates an empty adaptive card in version 1.2.
+func NewAdaptiveCard() AdaptiveCard {
+	return AdaptiveCard{Schema: AdaptiveCardSchema, Version: "1.2", Type: "AdaptiveCard", Body: []Element{}}
+}
+
+// NewTextBlock creates a TextBlock with the given text.
+func NewTextBlock(text string) TextBlock {
+	return TextBlock{Type: "TextBlock", Text: text, Wrap: true}
+}
+
+// NewContainer creates a Container holding the given elements.
+func NewContainer(items ...Element) Container {
+	return Container{Type: "Container", Items: append([]Element{}, items...)}
+}
+
+// NewFactSet creates a FactSet holding the given facts.
+func NewFactSet(facts ...Fact) FactSet {
+	return FactSet{Type: "FactSet", Facts: facts}
+}
+
+// NewActionSet creates an ActionSet holding the given actions.
+func NewActionSet(actions ...ActionOpenURL) ActionSet {
+	return ActionSet{Type: "ActionSet", Actions: actions}
+}
+
+// NewActionOpenURL creates an action opening the given URL.
+func NewActionOpenURL(title, url string) ActionOpenURL {
+	return ActionOpenURL{Type: "Action.OpenUrl", Title: title, URL: url}
+}
@@ -0,0 +1,51 @@
+package card
+
+import (
+	"text/template"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/resources"
+)
+
+// Options configures the Converter created by New.
+// The zero value renders the built-in card template without validating the cards.
+type Options struct {
+	// Template is the card template defining "teams.card", the built-in template if empty.
+	Template string
+	// Funcs are available in the template besides DefaultFuncs, replacing the functions of the same name.
+	Funcs template.FuncMap
+	// Schema validates the cards, see NewValidatingMiddleware. The cards are not validated if nil.
+	Schema *Schema
+	// EscapeUnderscores replaces all '_' with '\_' in the texts of the alerts.
+	EscapeUnderscores bool
+	// Logger logs the cards not matching the schema, if not nil.
+	Logger log.Logger
+}
+
+// New creates a Converter rendering the cards with a template.
+// Unlike ParseTemplateFile and DefaultSchema, it reads no files and uses no package state,
+// so it can be used to embed the conversion into other programs.
+func New(opts Options) (Converter, error) {
+	text := opts.Template
+	if text == "" {
+		text = resources.CardTemplate
+	}
+	funcs := DefaultFuncs()
+	for k, v := range opts.Funcs {
+		funcs[k] = v
+	}
+	tmpl, err := parseTemplate(text, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewTemplatedCardCreator(tmpl, opts.EscapeUnderscores)
+	if opts.Schema != nil {
+		logger := opts.Logger
+		if logger == nil {
+			logger = log.NewNopLogger()
+		}
+		c = NewValidatingMiddleware(logger, opts.Schema, c)
+	}
+	return c, nil
+}
@@ -0,0 +1,257 @@
+package card
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"go.opencensus.io/trace"
+)
+
+// Sections of a card created by the card builder.
+const (
+	SectionTitle         = "title"
+	SectionSeverity      = "severity"
+	SectionMessage       = "message"
+	SectionLabels        = "labels"
+	SectionAnnotations   = "annotations"
+	SectionRunbookAction = "runbook_action"
+	SectionSilenceAction = "silence_action"
+)
+
+var allSections = []string{
+	SectionTitle,
+	SectionSeverity,
+	SectionMessage,
+	SectionLabels,
+	SectionAnnotations,
+	SectionRunbookAction,
+	SectionSilenceAction,
+}
+
+// BuilderConfig configures the cards created by the card builder.
+// Empty fields are taken from DefaultBuilderConfig.
+type BuilderConfig struct {
+	// Sections lists the sections shown in the card, in any order.
+	Sections []string `yaml:"sections"`
+	// SeverityLabel is the alert label holding the severity.
+	SeverityLabel string `yaml:"severity_label"`
+	// SeverityColors maps severities to TextBlock colors
+	// (default, dark, light, accent, good, warning, attention).
+	SeverityColors map[string]string `yaml:"severity_colors"`
+	// MessageAnnotations are the annotations shown as message, the first non-empty one is used.
+	MessageAnnotations []string `yaml:"message_annotations"`
+	// RunbookAnnotation is the annotation holding the runbook URL.
+	RunbookAnnotation string `yaml:"runbook_annotation"`
+}
+
+// DefaultBuilderConfig shows all sections of a card.
+var DefaultBuilderConfig = BuilderConfig{
+	Sections:      allSections,
+	SeverityLabel: "severity",
+	SeverityColors: map[string]string{
+		"critical": "attention",
+		"error":    "attention",
+		"warning":  "warning",
+		"info":     "accent",
+	},
+	MessageAnnotations: []string{"message", "summary", "description"},
+	RunbookAnnotation:  "runbook_url",
+}
+
+// cardBuilder implements Converter by building the card from typed structs.
+// Unlike templates, it always produces valid JSON.
+type cardBuilder struct {
+	config   BuilderConfig
+	sections map[string]bool
+	// If true, replace all character `_` with `\_` in the texts of the card.
+	escapeUnderscores bool
+}
+
+// NewCardBuilder creates a cardBuilder.
+func NewCardBuilder(config BuilderConfig, escapeUnderscores bool) (Converter, error) {
+	if len(config.Sections) == 0 {
+		config.Sections = DefaultBuilderConfig.Sections
+	}
+	if config.SeverityLabel == "" {
+		config.SeverityLabel = DefaultBuilderConfig.SeverityLabel
+	}
+	if config.SeverityColors == nil {
+		config.SeverityColors = DefaultBuilderConfig.SeverityColors
+	}
+	if len(config.MessageAnnotations) == 0 {
+		config.MessageAnnotations = DefaultBuilderConfig.MessageAnnotations
+	}
+	if config.RunbookAnnotation == "" {
+		config.RunbookAnnotation = DefaultBuilderConfig.RunbookAnnotation
+	}
+
+	sections := map[string]bool{}
+	for _, s := range config.Sections {
+		if !contains(allSections, s) {
+			return nil, fmt.Errorf("unknown card section '%s', valid sections are %s", s, strings.Join(allSections, ", "))
+		}
+		sections[s] = true
+	}
+
+	return &cardBuilder{config, sections, escapeUnderscores}, nil
+}
+
+func (b *cardBuilder) Convert(ctx context.Context, promAlert webhook.Message) (string, error) {
+	_, span := trace.StartSpan(ctx, "cardBuilder.Convert")
+	defer span.End()
+
+	bs, err := json.Marshal(b.build(promAlert))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode card: %w", err)
+	}
+	return string(bs), nil
+}
+
+func (b *cardBuilder) build(promAlert webhook.Message) AdaptiveCard {
+	c := NewAdaptiveCard()
+
+	if b.sections[SectionTitle] {
+		title := NewTextBlock(b.title(promAlert))
+		title.Size = "large"
+		title.Weight = "bolder"
+		title.Color = statusColor(promAlert.Status)
+		c.Body = append(c.Body, title)
+	}
+
+	for _, a := range promAlert.Alerts {
+		// An alert without any of the shown sections would be an empty container.
+		if ct := b.alert(promAlert, a); len(ct.Items) > 0 {
+			c.Body = append(c.Body, ct)
+		}
+	}
+
+	return c
+}
+
+func (b *cardBuilder) title(promAlert webhook.Message) string {
+	title := strings.Title(promAlert.Status)
+	if n := len(promAlert.Alerts); n > 1 {
+		title = fmt.Sprintf("%s (%d)", title, n)
+	}
+	name := promAlert.GroupLabels["alertname"]
+	if name == "" {
+		name = promAlert.CommonLabels["alertname"]
+	}
+	if name != "" {
+		title = fmt.Sprintf("%s: %s", title, b.text(name))
+	}
+	return title
+}
+
+func (b *cardBuilder) alert(promAlert webhook.Message, a template.Alert) Container {
+	ct := NewContainer()
+	ct.Separator = true
+
+	if b.sections[SectionSeverity] {
+		if severity := a.Labels[b.config.SeverityLabel]; severity != "" {
+			tb := NewTextBlock(fmt.Sprintf("Severity: **%s**", b.text(severity)))
+			tb.Color = b.config.SeverityColors[severity]
+			if a.Status == "resolved" {
+				tb.Color = statusColor(a.Status)
+			}
+			ct.Items = append(ct.Items, tb)
+		}
+	}
+
+	var messageAnnotation string
+	if b.sections[SectionMessage] {
+		for _, name := range b.config.MessageAnnotations {
+			if v := a.Annotations[name]; v != "" {
+				messageAnnotation = name
+				ct.Items = append(ct.Items, NewTextBlock(b.text(v)))
+				break
+			}
+		}
+	}
+
+	if b.sections[SectionLabels] && len(a.Labels) > 0 {
+		ct.Items = append(ct.Items, heading("Labels"), b.facts(a.Labels, nil))
+	}
+
+	if b.sections[SectionAnnotations] {
+		skip := map[string]bool{messageAnnotation: true}
+		if b.sections[SectionRunbookAction] {
+			skip[b.config.RunbookAnnotation] = true
+		}
+		if fs := b.facts(a.Annotations, skip); len(fs.Facts) > 0 {
+			ct.Items = append(ct.Items, heading("Annotations"), fs)
+		}
+	}
+
+	var actions []ActionOpenURL
+	if b.sections[SectionRunbookAction] {
+		if u := a.Annotations[b.config.RunbookAnnotation]; u != "" {
+			actions = append(actions, NewActionOpenURL("Runbook", u))
+		}
+	}
+	if b.sections[SectionSilenceAction] && promAlert.ExternalURL != "" {
+		actions = append(actions, NewActionOpenURL("Silence", silenceURL(promAlert.ExternalURL, a.Labels)))
+	}
+	if len(actions) > 0 {
+		ct.Items = append(ct.Items, NewActionSet(actions...))
+	}
+
+	return ct
+}
+
+func (b *cardBuilder) facts(kv template.KV, skip map[string]bool) FactSet {
+	fs := NewFactSet()
+	for _, p := range kv.SortedPairs() {
+		if skip[p.Name] {
+			continue
+		}
+		fs.Facts = append(fs.Facts, Fact{Title: b.text(p.Name), Value: b.text(p.Value)})
+	}
+	return fs
+}
+
+// text escapes the markdown in s as configured.
+func (b *cardBuilder) text(s string) string {
+	if b.escapeUnderscores {
+		return strings.ReplaceAll(s, "_", `\_`)
+	}
+	return s
+}
+
+func heading(text string) TextBlock {
+	tb := NewTextBlock(text)
+	tb.Weight = "bolder"
+	tb.Spacing = "medium"
+	return tb
+}
+
+func statusColor(status string) string {
+	if status == "resolved" {
+		return "good"
+	}
+	return "attention"
+}
+
+// silenceURL returns the Alertmanager URL creating a silence for the given labels.
+func silenceURL(externalURL string, labels template.KV) string {
+	matchers := make([]string, 0, len(labels))
+	for _, p := range labels.SortedPairs() {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", p.Name, p.Value))
+	}
+	filter := url.QueryEscape("{" + strings.Join(matchers, ", ") + "}")
+	return fmt.Sprintf("%s/#/silences/new?filter=%s", externalURL, strings.ReplaceAll(filter, "+", "%20"))
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package card
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"testing"
+
+	"github.com/infonova/prometheus-webexteams/pkg/testutils"
+)
+
+var update = flag.Bool("update", false, "update .golden files")
+
+func TestCardBuilder(t *testing.T) {
+	tests := []struct {
+		name   string
+		config BuilderConfig
+		file   string
+	}{
+		{
+			name: "all sections",
+			file: "testdata/prometheus_fire_request.json",
+		},
+		{
+			name:   "title and labels only",
+			config: BuilderConfig{Sections: []string{SectionTitle, SectionLabels}},
+			file:   "testdata/prometheus_resolve_request.json",
+		},
+		{
+			name:   "title only",
+			config: BuilderConfig{Sections: []string{SectionTitle}},
+			file:   "testdata/prometheus_fire_request.json",
+		},
+		{
+			name:   "severity without severity label",
+			config: BuilderConfig{Sections: []string{SectionTitle, SectionSeverity}, SeverityLabel: "priority"},
+			file:   "testdata/prometheus_fire_request.json",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			wm, err := testutils.ParseWebhookJSONFromFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewCardBuilder(tt.config, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Convert(context.Background(), wm)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := testSchema(t).Validate(got); err != nil {
+				t.Fatalf("card is not valid: %v", err)
+			}
+
+			var v interface{}
+			if err := json.Unmarshal([]byte(got), &v); err != nil {
+				t.Fatal(err)
+			}
+			testutils.CompareToGoldenFile(t, v, t.Name()+"/card.json", *update)
+		})
+	}
+}
+
+func TestNewCardBuilder_unknownSection(t *testing.T) {
+	if _, err := NewCardBuilder(BuilderConfig{Sections: []string{"footer"}}, false); err == nil {
+		t.Fatal("want an error for an unknown section")
+	}
+}
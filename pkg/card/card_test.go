@@ -0,0 +1,74 @@
+package card
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/infonova/prometheus-webexteams/pkg/testutils"
+)
+
+func TestNew(t *testing.T) {
+	wm, err := testutils.ParseWebhookJSONFromFile("testdata/prometheus_fire_request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		contains string
+		wantErr  bool
+	}{
+		{
+			name:     "built-in template",
+			opts:     Options{Schema: testSchema(t)},
+			contains: `"type": "AdaptiveCard"`,
+		},
+		{
+			name: "custom template with funcs",
+			opts: Options{
+				Template: `{{ define "teams.card" }}{"type": "AdaptiveCard", "version": "1.2", "body": [{"type": "TextBlock", "text": "{{ shout .Status }}"}]}{{ end }}`,
+				Funcs:    template.FuncMap{"shout": func(s string) string { return strings.ToUpper(s) + "!" }},
+				Schema:   testSchema(t),
+			},
+			contains: `"text": "FIRING!"`,
+		},
+		{
+			name:     "invalid card replaced by the fallback card",
+			opts:     Options{Template: `{{ define "teams.card" }}{"body": []}{{ end }}`, Schema: testSchema(t)},
+			contains: `"type":"AdaptiveCard"`,
+		},
+		{
+			name:     "invalid card kept without schema",
+			opts:     Options{Template: `{{ define "teams.card" }}{"body": []}{{ end }}`},
+			contains: `{"body": []}`,
+		},
+		{
+			name:    "template without teams.card",
+			opts:    Options{Template: `{{ define "other" }}{}{{ end }}`},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := c.Convert(context.Background(), wm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.contains) {
+				t.Errorf("want card containing %s, got %s", tt.contains, got)
+			}
+		})
+	}
+}
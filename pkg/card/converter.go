@@ -2,13 +2,13 @@ package card
 
 import (
 	"context"
-	"fmt"
-	"os"
+	"errors"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/alertmanager/notify/webhook"
-	"github.com/xeipuuv/gojsonschema"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/trace"
 )
 
 // Converter converts an alert manager webhook message to Office365ConnectorCard.
@@ -21,8 +21,6 @@ type loggingMiddleware struct {
 	next   Converter
 }
 
-var schema = loadSchema()
-
 // NewCreatorLoggingMiddleware creates a loggingMiddleware.
 func NewCreatorLoggingMiddleware(l log.Logger, n Converter) Converter {
 	return loggingMiddleware{l, n}
@@ -30,24 +28,11 @@ func NewCreatorLoggingMiddleware(l log.Logger, n Converter) Converter {
 
 func (l loggingMiddleware) Convert(ctx context.Context, a webhook.Message) (c string, err error) {
 	defer func(begin time.Time) {
-		documentLoader := gojsonschema.NewStringLoader(c)
-
-		//TODO: implement error handling
-		result, err := schema.Validate(documentLoader)
 		if err != nil {
 			l.logger.Log("err", err)
 		} else {
-			if result.Valid() {
-				l.logger.Log("alert", "The document is valid ")
-				l.logger.Log("debug", c)
-			} else {
-				l.logger.Log("error", "The document is not valid. see errors :")
-				for _, desc := range result.Errors() {
-					l.logger.Log("error", desc)
-				}
-			}
+			l.logger.Log("debug", c)
 		}
-
 		l.logger.Log(
 			"alert", a,
 			"took", time.Since(begin),
@@ -56,11 +41,45 @@ func (l loggingMiddleware) Convert(ctx context.Context, a webhook.Message) (c st
 	return l.next.Convert(ctx, a)
 }
 
-func loadSchema() *gojsonschema.Schema {
-	schema, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://./resources/adaptive-card-schema.json"))
+type validatingMiddleware struct {
+	logger log.Logger
+	schema *Schema
+	next   Converter
+}
+
+// NewValidatingMiddleware creates a Converter validating the cards of the next converter against the schema.
+// Cards violating the schema are replaced by a plain text card listing the alerts, see FallbackCard,
+// since Webex would reject them. The violations are logged, recorded in MeasureInvalidCards
+// and annotated on the span.
+func NewValidatingMiddleware(l log.Logger, s *Schema, n Converter) Converter {
+	return validatingMiddleware{l, s, n}
+}
+
+func (v validatingMiddleware) Convert(ctx context.Context, a webhook.Message) (string, error) {
+	ctx, span := trace.StartSpan(ctx, "validatingMiddleware.Convert")
+	defer span.End()
+
+	c, err := v.next.Convert(ctx, a)
 	if err != nil {
-		fmt.Fprint(os.Stderr, err.Error())
-		os.Exit(1)
+		return "", err
+	}
+	err = v.schema.Validate(c)
+	if err == nil {
+		return c, nil
+	}
+
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		for _, desc := range ve.Errors {
+			v.logger.Log("err", "card does not match the adaptive card schema", "violation", desc)
+		}
+	} else {
+		v.logger.Log("err", err)
 	}
-	return schema
+	stats.Record(ctx, MeasureInvalidCards.M(1))
+	span.Annotate(
+		[]trace.Attribute{trace.StringAttribute("error", err.Error())},
+		"invalid card replaced by the fallback card",
+	)
+	return FallbackCard(a)
 }
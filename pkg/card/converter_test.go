@@ -0,0 +1,62 @@
+package card
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/testutils"
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+type staticConverter string
+
+func (c staticConverter) Convert(context.Context, webhook.Message) (string, error) {
+	return string(c), nil
+}
+
+func TestValidatingMiddleware(t *testing.T) {
+	wm, err := testutils.ParseWebhookJSONFromFile("testdata/prometheus_fire_request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback, err := FallbackCard(wm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		card string
+		want string
+	}{
+		{
+			name: "valid card is kept",
+			card: `{"type": "AdaptiveCard", "version": "1.2", "body": []}`,
+			want: `{"type": "AdaptiveCard", "version": "1.2", "body": []}`,
+		},
+		{
+			name: "invalid card is replaced by the fallback card",
+			card: `{"body": [{"type": "TextBlock"}]}`,
+			want: fallback,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewValidatingMiddleware(log.NewNopLogger(), testSchema(t), staticConverter(tt.card))
+			got, err := c.Convert(context.Background(), wm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want card %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if err := testSchema(t).Validate(fallback); err != nil {
+		t.Errorf("fallback card is not valid: %v", err)
+	}
+}
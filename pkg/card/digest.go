@@ -0,0 +1,40 @@
+package card
+
+import (
+	"sort"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// AlertGroup holds the alerts with the same alertname and status.
+type AlertGroup struct {
+	Name   string
+	Status string
+	Alerts template.Alerts
+}
+
+// GroupAlerts groups the alerts by alertname and status.
+// The groups are sorted by alertname, with the firing alerts before the resolved ones,
+// the alerts of a group keep their order.
+// It is available as 'groupAlerts' function in templates.
+func GroupAlerts(alerts template.Alerts) []AlertGroup {
+	var groups []AlertGroup
+	index := map[[2]string]int{}
+	for _, a := range alerts {
+		key := [2]string{a.Labels["alertname"], a.Status}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, AlertGroup{Name: key[0], Status: key[1]})
+		}
+		groups[i].Alerts = append(groups[i].Alerts, a)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].Status == "firing" && groups[j].Status != "firing"
+	})
+	return groups
+}
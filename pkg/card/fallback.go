@@ -0,0 +1,42 @@
+package card
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// FallbackCard creates a minimal card listing the alerts of the message as plain text.
+// It is built from typed elements only, so it always matches the adaptive card schema.
+// It is sent instead of cards which do not match the schema.
+func FallbackCard(wm webhook.Message) (string, error) {
+	c := NewAdaptiveCard()
+	if wm.Data == nil {
+		wm.Data = &template.Data{}
+	}
+
+	title := NewTextBlock(strings.TrimSpace(fmt.Sprintf("[%s:%d] %s", strings.ToUpper(wm.Status), len(wm.Alerts), wm.CommonLabels["alertname"])))
+	title.Size = "large"
+	title.Weight = "bolder"
+	c.Body = append(c.Body, title)
+
+	for _, a := range wm.Alerts {
+		text := a.Labels["alertname"]
+		for _, name := range []string{"summary", "message", "description"} {
+			if v := a.Annotations[name]; v != "" {
+				text += ": " + v
+				break
+			}
+		}
+		c.Body = append(c.Body, NewTextBlock(text))
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode fallback card: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,12 @@
+package card
+
+import (
+	"go.opencensus.io/stats"
+)
+
+// MeasureInvalidCards counts the cards which did not match the adaptive card schema and were replaced by the fallback card.
+var MeasureInvalidCards = stats.Int64(
+	"webexteams/invalid_cards",
+	"Number of cards not matching the adaptive card schema",
+	stats.UnitDimensionless,
+)
@@ -0,0 +1,79 @@
+package card
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/infonova/prometheus-webexteams/resources"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Schema validates cards against a JSON schema, e.g. resources/adaptive-card-schema.json.
+type Schema struct {
+	schema *gojsonschema.Schema
+}
+
+var (
+	defaultSchemaOnce sync.Once
+	defaultSchema     *Schema
+	defaultSchemaErr  error
+)
+
+// DefaultSchema returns the built-in adaptive card schema, resources/adaptive-card-schema.json.
+// It is loaded on first use.
+func DefaultSchema() (*Schema, error) {
+	defaultSchemaOnce.Do(func() {
+		defaultSchema, defaultSchemaErr = ParseSchema(resources.AdaptiveCardSchema)
+	})
+	return defaultSchema, defaultSchemaErr
+}
+
+// ParseSchema parses a JSON schema.
+func ParseSchema(schema string) (*Schema, error) {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	return &Schema{s}, nil
+}
+
+// LoadSchema loads the JSON schema from the given file.
+func LoadSchema(file string) (*Schema, error) {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(path)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema %s: %w", file, err)
+	}
+	return &Schema{s}, nil
+}
+
+// ValidationError lists the violations of the schema by a card.
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("card does not match the schema: %s", strings.Join(e.Errors, "; "))
+}
+
+// Validate validates the card against the schema.
+// A card violating the schema is reported as ValidationError.
+func (s *Schema) Validate(card string) error {
+	result, err := s.schema.Validate(gojsonschema.NewStringLoader(card))
+	if err != nil {
+		return fmt.Errorf("failed to validate card: %w", err)
+	}
+	if result.Valid() {
+		return nil
+	}
+	ve := &ValidationError{}
+	for _, desc := range result.Errors() {
+		ve.Errors = append(ve.Errors, desc.String())
+	}
+	return ve
+}
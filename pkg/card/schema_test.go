@@ -0,0 +1,39 @@
+package card
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSchema_Validate(t *testing.T) {
+	s, err := LoadSchema("../../resources/adaptive-card-schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := `{"type": "AdaptiveCard", "version": "1.2", "body": [{"type": "TextBlock", "text": "firing"}]}`
+	if err := s.Validate(valid); err != nil {
+		t.Errorf("want valid card, got %v", err)
+	}
+
+	var ve *ValidationError
+	if err := s.Validate(`{"body": []}`); !errors.As(err, &ve) || len(ve.Errors) == 0 {
+		t.Errorf("want ValidationError, got %v", err)
+	}
+}
+
+func TestDefaultSchema(t *testing.T) {
+	s := testSchema(t)
+	if err := s.Validate(`{"type": "AdaptiveCard", "version": "1.2", "body": []}`); err != nil {
+		t.Errorf("want valid card, got %v", err)
+	}
+}
+
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	s, err := DefaultSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,131 @@
+package card
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/infonova/prometheus-webexteams/resources"
+	"github.com/prometheus/alertmanager/asset"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"k8s.io/helm/pkg/engine"
+)
+
+// Template is a parsed card template defining "teams.card".
+// It is safe for concurrent use.
+type Template struct {
+	text *template.Template
+}
+
+// ParseTemplate parses a card template.
+// Besides the functions of the Alertmanager templates, the functions include all functions
+// (except 'env' and 'expandenv' ) from sprig (http://masterminds.github.io/sprig/)
+// and the following functions from HELM templating:
+//   - toToml
+//   - toYaml
+//   - fromYaml
+//   - toJson
+//   - fromJson
+//
+// and 'groupAlerts', see GroupAlerts.
+// The templates of the Alertmanager default template can be used too.
+func ParseTemplate(text string) (*Template, error) {
+	return parseTemplate(text, DefaultFuncs())
+}
+
+// parseTemplate parses a card template with the given functions.
+func parseTemplate(text string, funcs template.FuncMap) (*Template, error) {
+	t := template.New("").Option("missingkey=zero").Funcs(funcs)
+
+	f, err := asset.Assets.Open("/templates/default.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if t, err = t.Parse(string(b)); err != nil {
+		return nil, err
+	}
+
+	if t, err = t.Parse(text); err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	if t.Lookup("teams.card") == nil {
+		return nil, fmt.Errorf("the template does not define 'teams.card'")
+	}
+	return &Template{t}, nil
+}
+
+// ParseTemplateFile parses the card template of the given file, see ParseTemplate.
+func ParseTemplateFile(f string) (*Template, error) {
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file: %w", err)
+	}
+	t, err := ParseTemplate(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f, err)
+	}
+	return t, nil
+}
+
+// DefaultTemplate parses the built-in card template, resources/default-message-card.tmpl.
+func DefaultTemplate() (*Template, error) {
+	return ParseTemplate(resources.CardTemplate)
+}
+
+// DigestTemplate parses the built-in digest card template, resources/digest-card.tmpl.
+func DigestTemplate() (*Template, error) {
+	return ParseTemplate(resources.DigestCardTemplate)
+}
+
+// MSTeamsTemplate parses the built-in MessageCard template for Microsoft Teams incoming webhooks,
+// resources/msteams-message-card.tmpl.
+func MSTeamsTemplate() (*Template, error) {
+	return ParseTemplate(resources.MSTeamsCardTemplate)
+}
+
+// SlackTemplate parses the built-in message template for Slack incoming webhooks, resources/slack-message.tmpl.
+func SlackTemplate() (*Template, error) {
+	return ParseTemplate(resources.SlackMessageTemplate)
+}
+
+// WebhookTemplate parses the built-in body template of the webhook backend, resources/webhook-body.tmpl,
+// which renders the alerts of the notification in JSON.
+func WebhookTemplate() (*Template, error) {
+	return ParseTemplate(resources.WebhookBodyTemplate)
+}
+
+// execute executes the named template.
+func (t *Template) execute(name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// DefaultFuncs returns the functions available in card templates, see ParseTemplate.
+// The returned map is a copy which may be modified.
+func DefaultFuncs() template.FuncMap {
+	funcs := template.FuncMap{}
+	for k, v := range amtemplate.DefaultFuncs {
+		funcs[k] = v
+	}
+	for k, v := range engine.FuncMap() {
+		funcs[k] = v
+	}
+	funcs["groupAlerts"] = GroupAlerts
+	funcs["counter"] = func() func() int {
+		i := -1
+		return func() int {
+			i++
+			return i
+		}
+	}
+	return funcs
+}
@@ -0,0 +1,65 @@
+package card
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/infonova/prometheus-webexteams/pkg/testutils"
+)
+
+func TestBackendTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		template func() (*Template, error)
+		contains []string
+	}{
+		{
+			name:     "msteams",
+			template: MSTeamsTemplate,
+			contains: []string{`"@type": "MessageCard"`, `"title": "Prometheus Alert (firing)"`},
+		},
+		{
+			name:     "slack",
+			template: SlackTemplate,
+			contains: []string{`"attachments": [`, `"color": "warning"`},
+		},
+		{
+			name:     "webhook",
+			template: WebhookTemplate,
+			contains: []string{`"receiver":"teams_proxy"`, `"status":"firing"`},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := tt.template()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range []string{"testdata/prometheus_fire_request.json", "testdata/prometheus_resolve_request.json"} {
+				wm, err := testutils.ParseWebhookJSONFromFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := NewTemplatedCardCreator(tmpl, false).Convert(context.Background(), wm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !json.Valid([]byte(got)) {
+					t.Fatalf("%s: the message is not valid JSON: %s", file, got)
+				}
+				if wm.Status != "firing" {
+					continue
+				}
+				for _, c := range tt.contains {
+					if !strings.Contains(got, c) {
+						t.Errorf("want message containing %s, got %s", c, got)
+					}
+				}
+			}
+		})
+	}
+}
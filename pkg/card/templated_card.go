@@ -5,24 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/prometheus/alertmanager/notify/webhook"
 	"github.com/prometheus/alertmanager/template"
 	"go.opencensus.io/trace"
-	"k8s.io/helm/pkg/engine"
 )
 
 // templatedCard implements Converter using Alert manager templating.
 type templatedCard struct {
-	template *template.Template
+	template *Template
 	// If true, replace all character `_` with `\\_` in the prometheus alert.
 	escapeUnderscores bool
 }
 
 // NewTemplatedCardCreator creates a templatedCard.
-func NewTemplatedCardCreator(template *template.Template, escapeUnderscores bool) Converter {
+func NewTemplatedCardCreator(template *Template, escapeUnderscores bool) Converter {
 	return &templatedCard{template, escapeUnderscores}
 }
 
@@ -53,9 +51,7 @@ func (m *templatedCard) executeTemplate(promAlert webhook.Message) (string, erro
 		ExternalURL:       promAlert.ExternalURL,
 	}
 
-	cardString, err := m.template.ExecuteTextString(
-		`{{ template "teams.card" . }}`, data,
-	)
+	cardString, err := m.template.execute("teams.card", data)
 	if err != nil {
 		return "", fmt.Errorf("failed to template alerts: %w", err)
 	}
@@ -73,57 +69,33 @@ func jsonEncode(str string) string {
 	return string(buf.Bytes()[1 : len(buf.Bytes())-2])
 }
 
-// json escape all string values in kvData and also escape
-// '_' char so it does not get processed as markdown italic
-func jsonEncodeAlertmanagerKV(kvData template.KV) {
+// jsonEscapeKV returns a copy of kvData with all values json escaped, and '_' escaped
+// so it does not get processed as markdown italic.
+func jsonEscapeKV(kvData template.KV) template.KV {
+	escaped := make(template.KV, len(kvData))
 	for k, v := range kvData {
-		kvData[k] = strings.ReplaceAll(jsonEncode(v), `_`, `\\_`)
+		escaped[k] = strings.ReplaceAll(jsonEncode(v), `_`, `\\_`)
 	}
+	return escaped
 }
 
+// jsonEscapeMessage returns a copy of the message with all label and annotation values escaped.
+// The message itself is not modified, since its maps are shared with the other conversions
+// of the message, e.g. of the pages of a split alert group or of the rooms it is routed to.
 func jsonEscapeMessage(promAlert webhook.Message) webhook.Message {
-	retPromAlert := promAlert
-	jsonEncodeAlertmanagerKV(retPromAlert.GroupLabels)
-	jsonEncodeAlertmanagerKV(retPromAlert.CommonLabels)
-	jsonEncodeAlertmanagerKV(retPromAlert.CommonAnnotations)
-	for _, alert := range retPromAlert.Alerts {
-		jsonEncodeAlertmanagerKV(alert.Labels)
-		jsonEncodeAlertmanagerKV(alert.Annotations)
+	if promAlert.Data == nil {
+		return promAlert
 	}
-	return retPromAlert
-}
-
-/* ParseTemplateFile creates an alertmanager template from the given file.
-The functions include all functions (except 'env' and 'expandenv' ) from sprig (http://masterminds.github.io/sprig/)
-and the following functions from HELM templating:
-  - toToml
-  - toYaml
-  - fromYaml
-  - toJson
-  - fromJson
-*/
-func ParseTemplateFile(f string) (*template.Template, error) {
-	funcs := template.DefaultFuncs
-	for k, v := range engine.FuncMap() {
-		funcs[k] = v
-	}
-	funcs["counter"] = func() func() int {
-		i := -1
-		return func() int {
-			i++
-			return i
-		}
-	}
-	template.DefaultFuncs = funcs
-
-	if _, err := os.Stat(f); os.IsNotExist(err) {
-		return nil, fmt.Errorf("template file %s does not exist", f)
+	data := *promAlert.Data
+	data.GroupLabels = jsonEscapeKV(data.GroupLabels)
+	data.CommonLabels = jsonEscapeKV(data.CommonLabels)
+	data.CommonAnnotations = jsonEscapeKV(data.CommonAnnotations)
+	data.Alerts = make(template.Alerts, len(promAlert.Alerts))
+	for i, alert := range promAlert.Alerts {
+		alert.Labels = jsonEscapeKV(alert.Labels)
+		alert.Annotations = jsonEscapeKV(alert.Annotations)
+		data.Alerts[i] = alert
 	}
-
-	tmpl, err := template.FromGlobs(f)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse template: %v: %v", err, err)
-	}
-
-	return tmpl, nil
+	promAlert.Data = &data
+	return promAlert
 }
@@ -0,0 +1,35 @@
+package card
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// AppendTextBlock appends a TextBlock with the given text to the body of an adaptive card.
+// It works on the JSON of any card, no matter whether it was created by a template or the card builder.
+func AppendTextBlock(card string, text string) (string, error) {
+	var c map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader([]byte(card)))
+	d.UseNumber()
+	if err := d.Decode(&c); err != nil {
+		return "", fmt.Errorf("failed to decode card: %w", err)
+	}
+
+	body, ok := c["body"].([]interface{})
+	if !ok && c["body"] != nil {
+		return "", errors.New("the body of the card is not a list")
+	}
+	tb := NewTextBlock(text)
+	tb.Size = "small"
+	tb.Weight = "lighter"
+	tb.Separator = true
+	c["body"] = append(body, tb)
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode card: %w", err)
+	}
+	return string(b), nil
+}
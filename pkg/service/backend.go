@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"go.opencensus.io/trace"
+)
+
+// Backend is the chat platform a connector delivers its messages to.
+type Backend string
+
+const (
+	// BackendWebex sends the cards to Webex rooms and persons through the Webex messages API.
+	BackendWebex Backend = "webex"
+	// BackendMSTeams posts the cards to a Microsoft Teams incoming webhook.
+	BackendMSTeams Backend = "msteams"
+	// BackendSlack posts the messages to a Slack incoming webhook.
+	BackendSlack Backend = "slack"
+	// BackendWebhook posts the rendered body to an arbitrary URL, e.g. of an internal system.
+	BackendWebhook Backend = "webhook"
+)
+
+// ParseBackend parses the name of a backend, BackendWebex if empty.
+func ParseBackend(s string) (Backend, error) {
+	switch b := Backend(s).WithDefaults(); b {
+	case BackendWebex, BackendMSTeams, BackendSlack, BackendWebhook:
+		return b, nil
+	}
+	return "", fmt.Errorf(
+		"unknown backend %q, must be one of %q, %q, %q or %q",
+		s, BackendWebex, BackendMSTeams, BackendSlack, BackendWebhook,
+	)
+}
+
+// WithDefaults returns the backend, BackendWebex if empty.
+func (b Backend) WithDefaults() Backend {
+	if b == "" {
+		return BackendWebex
+	}
+	return b
+}
+
+// incomingWebhookService posts the converted messages as they are to a webhook,
+// the incoming webhook of a channel or the URL of the webhook backend.
+type incomingWebhookService struct {
+	sender
+	converter  card.Converter
+	webhookURL string
+}
+
+// IncomingWebhookOptions configures an incomingWebhookService.
+type IncomingWebhookOptions struct {
+	// Backend is the platform of the webhook, BackendMSTeams or BackendSlack.
+	Backend Backend
+	// WebhookURL is the incoming webhook of the channel receiving the messages.
+	WebhookURL string
+	// Retry is the retry policy of failed requests.
+	Retry RetryPolicy
+	// Failures maps failed deliveries to the status codes returned to Alertmanager.
+	Failures FailureMapping
+}
+
+// NewIncomingWebhookService creates a service posting the converted messages as they are
+// to an incoming webhook of Microsoft Teams or Slack.
+// The channel of the messages is determined by the webhook, so there is no routing, threading or queueing.
+func NewIncomingWebhookService(converter card.Converter, client *http.Client, opts IncomingWebhookOptions) Service {
+	return incomingWebhookService{
+		sender: sender{
+			backend:  opts.Backend,
+			client:   client,
+			retry:    opts.Retry.WithDefaults(),
+			failures: opts.Failures.WithDefaults(),
+		},
+		converter:  converter,
+		webhookURL: opts.WebhookURL,
+	}
+}
+
+// WebhookOptions configures the service of the webhook backend.
+type WebhookOptions struct {
+	// URL receives the rendered bodies.
+	URL string
+	// Headers are sent with every request, replacing the default Content-Type application/json.
+	Headers http.Header
+	// Retry is the retry policy of failed requests.
+	Retry RetryPolicy
+	// Failures maps failed deliveries to the status codes returned to Alertmanager.
+	Failures FailureMapping
+}
+
+// NewWebhookService creates a service posting the converted messages as request body to an arbitrary URL,
+// so the alerts can be relayed to other systems. The converter may render any body, not only JSON.
+func NewWebhookService(converter card.Converter, client *http.Client, opts WebhookOptions) Service {
+	return incomingWebhookService{
+		sender: sender{
+			backend:  BackendWebhook,
+			client:   client,
+			retry:    opts.Retry.WithDefaults(),
+			failures: opts.Failures.WithDefaults(),
+			headers:  opts.Headers,
+		},
+		converter:  converter,
+		webhookURL: opts.URL,
+	}
+}
+
+func (s incomingWebhookService) Post(ctx context.Context, wm webhook.Message) (PostResponse, error) {
+	ctx, span := trace.StartSpan(ctx, "incomingWebhookService.Post")
+	defer span.End()
+
+	c, err := s.converter.Convert(ctx, wm)
+	if err != nil {
+		return PostResponse{WebhookURL: s.webhookURL}, fmt.Errorf("failed to parse webhook message: %w", err)
+	}
+	return s.postWithRetry(ctx, s.webhookURL, c)
+}
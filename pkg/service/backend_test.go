@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Backend
+		wantErr bool
+	}{
+		{in: "", want: BackendWebex},
+		{in: "webex", want: BackendWebex},
+		{in: "msteams", want: BackendMSTeams},
+		{in: "slack", want: BackendSlack},
+		{in: "webhook", want: BackendWebhook},
+		{in: "teams", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBackend(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: wantErr %v, got %v", tt.in, tt.wantErr, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func Test_incomingWebhookService_Post(t *testing.T) {
+	var (
+		bodies []string
+		calls  int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("unexpected authorization %q", auth)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	s := NewIncomingWebhookService(
+		staticConverter(`{"text": "firing"}`),
+		srv.Client(),
+		IncomingWebhookOptions{
+			Backend:    BackendSlack,
+			WebhookURL: srv.URL,
+			Retry:      RetryPolicy{MaxAttempts: 2, InitialInterval: time.Millisecond},
+		},
+	)
+
+	pr, err := s.Post(context.Background(), webhook.Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Status != http.StatusOK || pr.Attempts != 2 || pr.Message != "ok" {
+		t.Errorf("want status 200 after 2 attempts, got %+v", pr)
+	}
+	for _, b := range bodies {
+		if b != `{"text": "firing"}` {
+			t.Errorf("want the converted message as request body, got %s", b)
+		}
+	}
+
+	// A rejected message is reported as DeliveryError.
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	_, err = s.Post(context.Background(), webhook.Message{})
+	var de *DeliveryError
+	if !errors.As(err, &de) || de.Status != http.StatusBadRequest {
+		t.Fatalf("want a DeliveryError with status 400, got %v", err)
+	}
+}
+
+func Test_webhookService_Post(t *testing.T) {
+	var (
+		got  *http.Request
+		body string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	headers := http.Header{}
+	headers.Set("Content-Type", "text/plain")
+	headers.Set("X-Api-Key", "secret")
+	s := NewWebhookService(staticConverter("firing: HighScrapeDuration"), srv.Client(), WebhookOptions{
+		URL:     srv.URL + "/events",
+		Headers: headers,
+	})
+
+	pr, err := s.Post(context.Background(), webhook.Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Status != http.StatusAccepted {
+		t.Errorf("want status %d, got %d", http.StatusAccepted, pr.Status)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/events" {
+		t.Errorf("want POST /events, got %s %s", got.Method, got.URL.Path)
+	}
+	if body != "firing: HighScrapeDuration" {
+		t.Errorf("want the converted message as request body, got %s", body)
+	}
+	if ct := got.Header.Values("Content-Type"); len(ct) != 1 || ct[0] != "text/plain" {
+		t.Errorf("want Content-Type text/plain, got %v", ct)
+	}
+	if key := got.Header.Get("X-Api-Key"); key != "secret" {
+		t.Errorf("want X-Api-Key secret, got %q", key)
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+// DefaultWebhookURL is the Webex API endpoint creating messages.
+const DefaultWebhookURL = "https://webexapis.com/v1/messages"
+
+// Client sends Alertmanager webhook messages to Webex, for use as a library.
+// It needs no files: the built-in request template is used unless Options.RequestTemplate is set,
+// and the messages are only queued if Options.Queue is set.
+type Client struct {
+	service Service
+}
+
+// NewClient creates a Client sending the cards of the converter, see card.New.
+// The client falls back to http.DefaultClient, and the WebhookURL of the options to DefaultWebhookURL.
+func NewClient(converter card.Converter, client *http.Client, opts Options) (*Client, error) {
+	if converter == nil {
+		return nil, errors.New("a card converter is required")
+	}
+	if opts.AccessToken == "" && opts.Tokens == nil {
+		return nil, errors.New("an access token or token source is required")
+	}
+	if len(opts.Targets) == 0 && len(opts.Rules) == 0 && len(opts.RecipientLabels) == 0 && len(opts.RecipientAnnotations) == 0 {
+		return nil, errors.New("targets, rules or recipients are required")
+	}
+	if err := opts.Split.Validate(); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if opts.WebhookURL == "" {
+		opts.WebhookURL = DefaultWebhookURL
+	}
+	return &Client{NewSimpleService(converter, client, opts)}, nil
+}
+
+// Post implements Service.
+func (c *Client) Post(ctx context.Context, wm webhook.Message) (PostResponse, error) {
+	return c.service.Post(ctx, wm)
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient(staticConverter("{}"), nil, Options{Targets: RoomTargets("room")}); err == nil {
+		t.Error("want an error without access token")
+	}
+	if _, err := NewClient(staticConverter("{}"), nil, Options{AccessToken: "token"}); err == nil {
+		t.Error("want an error without targets")
+	}
+
+	var req map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Errorf("invalid request %s: %v", b, err)
+		}
+		_, _ = w.Write([]byte(`{"id": "message"}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(staticConverter(`{"type": "AdaptiveCard"}`), srv.Client(), Options{
+		WebhookURL:  srv.URL,
+		AccessToken: "token",
+		Targets:     RoomTargets("room"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := c.Post(context.Background(), webhook.Message{Data: &template.Data{Status: "firing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.MessageID != "message" {
+		t.Errorf("want message ID %q, got %q", "message", pr.MessageID)
+	}
+	if req["roomId"] != "room" {
+		t.Errorf("want roomId %q, got %v", "room", req["roomId"])
+	}
+}
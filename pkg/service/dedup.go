@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/trace"
+)
+
+// DedupCache holds the notifications sent recently.
+// It is kept apart from the dedupService, so the window survives a config reload.
+type DedupCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewDedupCache creates an empty DedupCache.
+func NewDedupCache() *DedupCache {
+	return &DedupCache{seen: map[string]time.Time{}}
+}
+
+// dedupService is a middleware for Service dropping notifications identical to one sent within the window.
+// Notifications are identical if they have the same group key, status and alerts.
+type dedupService struct {
+	window time.Duration
+	cache  *DedupCache
+	next   Service
+	now    func() time.Time
+}
+
+// NewDedupService creates a dedupService remembering the sent notifications in the cache.
+func NewDedupService(window time.Duration, cache *DedupCache, next Service) Service {
+	return &dedupService{window: window, cache: cache, next: next, now: time.Now}
+}
+
+func (s *dedupService) Post(ctx context.Context, wm webhook.Message) (PostResponse, error) {
+	ctx, span := trace.StartSpan(ctx, "dedupService.Post")
+	defer span.End()
+
+	key := notificationKey(wm)
+	if !s.claim(key) {
+		span.Annotate(nil, "duplicate notification suppressed")
+		stats.Record(ctx, MeasureSuppressed.M(1))
+		return PostResponse{Status: http.StatusOK, Message: "duplicate notification suppressed"}, nil
+	}
+
+	pr, err := s.next.Post(ctx, wm)
+	if err != nil {
+		// Alertmanager retries failed notifications, which must not be suppressed.
+		s.release(key)
+	}
+	return pr, err
+}
+
+// claim marks the notification as seen and reports whether it was not seen within the window.
+// The notification is marked before it is sent, so concurrent duplicates are suppressed too.
+func (s *dedupService) claim(key string) bool {
+	c := s.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := s.now()
+	for k, t := range c.seen {
+		if now.Sub(t) >= s.window {
+			delete(c.seen, k)
+		}
+	}
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = now
+	return true
+}
+
+func (s *dedupService) release(key string) {
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	delete(s.cache.seen, key)
+}
+
+// notificationKey hashes the group key, the status and the fingerprints and status of the alerts of a notification.
+func notificationKey(wm webhook.Message) string {
+	h := sha256.New()
+	h.Write([]byte(wm.GroupKey))
+	if wm.Data != nil {
+		h.Write([]byte{0})
+		h.Write([]byte(wm.Status))
+		alerts := make([]string, 0, len(wm.Alerts))
+		for _, a := range wm.Alerts {
+			alerts = append(alerts, alertKey(a)+":"+a.Status)
+		}
+		sort.Strings(alerts)
+		for _, a := range alerts {
+			h.Write([]byte{0})
+			h.Write([]byte(a))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// alertKey identifies an alert by its fingerprint.
+func alertKey(a template.Alert) string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	// Older Alertmanager versions do not send fingerprints.
+	var key string
+	for _, p := range a.Labels.SortedPairs() {
+		key += p.Name + "=" + p.Value + ","
+	}
+	return key
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+type countingService struct {
+	posts int
+	err   error
+}
+
+func (s *countingService) Post(context.Context, webhook.Message) (PostResponse, error) {
+	s.posts++
+	return PostResponse{Status: 200}, s.err
+}
+
+func Test_dedupService(t *testing.T) {
+	now := time.Unix(0, 0)
+	next := &countingService{}
+	cache := NewDedupCache()
+	s := NewDedupService(time.Minute, cache, next).(*dedupService)
+	s.now = func() time.Time { return now }
+
+	message := func(status string, fingerprints ...string) webhook.Message {
+		data := &template.Data{Status: status}
+		for _, fp := range fingerprints {
+			data.Alerts = append(data.Alerts, template.Alert{Status: status, Fingerprint: fp})
+		}
+		return webhook.Message{Data: data, GroupKey: "group"}
+	}
+
+	steps := []struct {
+		name      string
+		wm        webhook.Message
+		advance   time.Duration
+		err       error
+		wantPosts int
+	}{
+		{"first notification", message("firing", "a", "b"), 0, nil, 1},
+		{"duplicate", message("firing", "b", "a"), 30 * time.Second, nil, 1},
+		{"new alert", message("firing", "a", "b", "c"), 0, nil, 2},
+		{"resolved", message("resolved", "a", "b", "c"), 0, nil, 3},
+		{"after the window", message("firing", "a", "b"), time.Minute, nil, 4},
+		{"failed", message("firing", "d"), 0, errors.New("failed"), 5},
+		{"retry of the failed", message("firing", "d"), 0, nil, 6},
+		{"after a reload", message("firing", "d"), 0, nil, 6},
+	}
+	for _, step := range steps {
+		if step.name == "after a reload" {
+			// A reload creates a new service sharing the cache.
+			s = NewDedupService(time.Minute, cache, next).(*dedupService)
+			s.now = func() time.Time { return now }
+		}
+		now = now.Add(step.advance)
+		next.err = step.err
+		_, _ = s.Post(context.Background(), step.wm)
+		if next.posts != step.wantPosts {
+			t.Errorf("%s: want %d posts, got %d", step.name, step.wantPosts, next.posts)
+		}
+	}
+}
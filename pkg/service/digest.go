@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+	"go.opencensus.io/trace"
+)
+
+// Flusher sends the alerts it collected once they are due.
+type Flusher interface {
+	Due(now time.Time) bool
+	Flush(ctx context.Context) error
+}
+
+// DigestBatch collects the alerts of a digest until they are sent.
+// It is kept apart from the DigestService, so the collected alerts survive a config reload.
+type DigestBatch struct {
+	mu        sync.Mutex
+	base      webhook.Message
+	alerts    map[string]template.Alert
+	lastFlush time.Time
+}
+
+// NewDigestBatch creates an empty DigestBatch.
+func NewDigestBatch() *DigestBatch {
+	return &DigestBatch{alerts: map[string]template.Alert{}, lastFlush: time.Now()}
+}
+
+// add merges the alerts of the message into the batch, newer states replace older ones.
+func (b *DigestBatch) add(wm webhook.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if wm.Data == nil {
+		return
+	}
+	b.base = wm
+	for _, a := range wm.Alerts {
+		b.alerts[alertKey(a)] = a
+	}
+}
+
+// take empties the batch and returns a message holding its alerts, or false if it is empty.
+func (b *DigestBatch) take(now time.Time) (webhook.Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastFlush = now
+	if len(b.alerts) == 0 {
+		return webhook.Message{}, false
+	}
+	alerts := make([]template.Alert, 0, len(b.alerts))
+	for _, a := range b.alerts {
+		alerts = append(alerts, a)
+	}
+	b.alerts = map[string]template.Alert{}
+	sort.Slice(alerts, func(i, j int) bool {
+		if ni, nj := alerts[i].Labels["alertname"], alerts[j].Labels["alertname"]; ni != nj {
+			return ni < nj
+		}
+		if alerts[i].Status != alerts[j].Status {
+			return alerts[i].Status == "firing"
+		}
+		return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+	})
+
+	wm := withAlerts(b.base, alerts)
+	wm.GroupLabels = template.KV{}
+	// The digest is not an alert group, it must neither be threaded nor deduplicated.
+	wm.GroupKey = ""
+	return wm, true
+}
+
+// restore adds the alerts of a digest that could not be sent back, unless newer states were collected meanwhile.
+func (b *DigestBatch) restore(wm webhook.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, a := range wm.Alerts {
+		if _, ok := b.alerts[alertKey(a)]; !ok {
+			b.alerts[alertKey(a)] = a
+		}
+	}
+}
+
+// DigestService is a Service collecting the alerts of all notifications,
+// which are sent as one digest message every interval.
+type DigestService struct {
+	interval time.Duration
+	batch    *DigestBatch
+	next     Service
+}
+
+// NewDigestService creates a DigestService collecting the alerts in the batch and sending the digest with next.
+func NewDigestService(interval time.Duration, batch *DigestBatch, next Service) *DigestService {
+	return &DigestService{interval: interval, batch: batch, next: next}
+}
+
+// Post adds the alerts of the notification to the next digest.
+func (s *DigestService) Post(ctx context.Context, wm webhook.Message) (PostResponse, error) {
+	_, span := trace.StartSpan(ctx, "DigestService.Post")
+	defer span.End()
+
+	s.batch.add(wm)
+	return PostResponse{Status: http.StatusAccepted, Message: "added to digest"}, nil
+}
+
+// Due reports whether the interval passed since the last digest.
+func (s *DigestService) Due(now time.Time) bool {
+	s.batch.mu.Lock()
+	defer s.batch.mu.Unlock()
+
+	return now.Sub(s.batch.lastFlush) >= s.interval
+}
+
+// Flush sends the digest of the collected alerts, if there are any.
+// The alerts of a digest that could not be sent are kept for the next one.
+func (s *DigestService) Flush(ctx context.Context) error {
+	ctx, span := trace.StartSpan(ctx, "DigestService.Flush")
+	defer span.End()
+
+	wm, ok := s.batch.take(time.Now())
+	if !ok {
+		return nil
+	}
+	if _, err := s.next.Post(ctx, wm); err != nil {
+		s.batch.restore(wm)
+		return fmt.Errorf("failed to send digest of %d alerts: %w", len(wm.Alerts), err)
+	}
+	return nil
+}
+
+// RunFlusher flushes the due flushers every tick until ctx is done, and all of them afterwards.
+// The flushers are retrieved on every tick, so they may change over time.
+func RunFlusher(ctx context.Context, logger log.Logger, tick time.Duration, flushers func() []Flusher) error {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Send the collected alerts before shutting down.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			for _, f := range flushers() {
+				if err := f.Flush(flushCtx); err != nil {
+					logger.Log("message", "flush on shutdown failed", "err", err)
+				}
+			}
+			return nil
+		case now := <-t.C:
+			for _, f := range flushers() {
+				if !f.Due(now) {
+					continue
+				}
+				if err := f.Flush(ctx); err != nil {
+					logger.Log("message", "flush failed", "err", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+type recordingService struct {
+	messages []webhook.Message
+	err      error
+}
+
+func (s *recordingService) Post(_ context.Context, wm webhook.Message) (PostResponse, error) {
+	s.messages = append(s.messages, wm)
+	return PostResponse{Status: 200}, s.err
+}
+
+func TestDigestService(t *testing.T) {
+	alert := func(name, status, fp string) template.Alert {
+		return template.Alert{Status: status, Fingerprint: fp, Labels: template.KV{"alertname": name, "team": "x"}}
+	}
+	message := func(alerts ...template.Alert) webhook.Message {
+		return webhook.Message{Data: &template.Data{Receiver: "digest", Alerts: alerts}, GroupKey: "group"}
+	}
+
+	next := &recordingService{}
+	s := NewDigestService(time.Minute, NewDigestBatch(), next)
+	if s.Due(time.Now()) {
+		t.Error("want the first digest to be due after the interval")
+	}
+
+	for _, wm := range []webhook.Message{
+		message(alert("B", "firing", "1"), alert("A", "firing", "2")),
+		message(alert("A", "firing", "3")),
+		message(alert("B", "resolved", "1")),
+	} {
+		if pr, err := s.Post(context.Background(), wm); err != nil || pr.Status != 202 {
+			t.Fatalf("want 202, got %d, %v", pr.Status, err)
+		}
+	}
+
+	next.err = errors.New("unavailable")
+	if err := s.Flush(context.Background()); err == nil {
+		t.Fatal("want the failed digest to be reported")
+	}
+	next.err = nil
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(next.messages) != 2 {
+		t.Fatalf("want the failed digest to be resent and no empty digest, got %d digests", len(next.messages))
+	}
+	digest := next.messages[1]
+	var got []string
+	for _, a := range digest.Alerts {
+		got = append(got, a.Labels["alertname"]+"/"+a.Status)
+	}
+	if want := []string{"A/firing", "A/firing", "B/resolved"}; len(got) != len(want) || got[0] != want[0] || got[2] != want[2] {
+		t.Errorf("want alerts %v, got %v", want, got)
+	}
+	if digest.Status != "firing" || digest.CommonLabels["team"] != "x" || digest.GroupKey != "" {
+		t.Errorf("unexpected digest data %+v, group key %q", digest.Data, digest.GroupKey)
+	}
+}
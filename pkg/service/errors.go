@@ -0,0 +1,62 @@
+package service
+
+import "net/http"
+
+// FailureMapping maps failed Webex deliveries to the HTTP status code answered to Alertmanager.
+// Alertmanager retries notifications answered with a 5xx status and gives up on 4xx.
+type FailureMapping struct {
+	// ClientErrorStatus is used when Webex rejected the message with a 4xx status other than 429.
+	ClientErrorStatus int `yaml:"client_error_status"`
+	// ServerErrorStatus is used when Webex answered with 429 or a 5xx status.
+	ServerErrorStatus int `yaml:"server_error_status"`
+	// TransportErrorStatus is used when Webex could not be reached at all.
+	TransportErrorStatus int `yaml:"transport_error_status"`
+}
+
+// DefaultFailureMapping is used for every field left empty in a FailureMapping.
+var DefaultFailureMapping = FailureMapping{
+	ClientErrorStatus:    http.StatusBadRequest,
+	ServerErrorStatus:    http.StatusBadGateway,
+	TransportErrorStatus: http.StatusServiceUnavailable,
+}
+
+// WithDefaults returns a copy of m where unset fields are taken from DefaultFailureMapping.
+func (m FailureMapping) WithDefaults() FailureMapping {
+	if m.ClientErrorStatus == 0 {
+		m.ClientErrorStatus = DefaultFailureMapping.ClientErrorStatus
+	}
+	if m.ServerErrorStatus == 0 {
+		m.ServerErrorStatus = DefaultFailureMapping.ServerErrorStatus
+	}
+	if m.TransportErrorStatus == 0 {
+		m.TransportErrorStatus = DefaultFailureMapping.TransportErrorStatus
+	}
+	return m
+}
+
+// status returns the status code for a delivery that ended with the given Webex status code.
+// A zero webexStatus means that no response was received.
+func (m FailureMapping) status(webexStatus int) int {
+	switch {
+	case webexStatus == 0:
+		return m.TransportErrorStatus
+	case webexStatus == http.StatusTooManyRequests || webexStatus >= 500:
+		return m.ServerErrorStatus
+	default:
+		return m.ClientErrorStatus
+	}
+}
+
+// DeliveryError is returned by a Service when a message could not be delivered to Webex.
+type DeliveryError struct {
+	// Status is the HTTP status code to answer the caller with.
+	Status int
+	Err    error
+}
+
+func (e *DeliveryError) Error() string { return e.Err.Error() }
+
+func (e *DeliveryError) Unwrap() error { return e.Err }
+
+// StatusCode returns the HTTP status code to answer the caller with.
+func (e *DeliveryError) StatusCode() int { return e.Status }
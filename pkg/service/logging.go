@@ -24,6 +24,7 @@ func (s loggingService) Post(ctx context.Context, wm webhook.Message) (pr PostRe
 		level.Debug(s.logger).Log(
 			"response_message", pr.Message,
 			"response_status", pr.Status,
+			"attempts", pr.Attempts,
 			"webhook_url", pr.WebhookURL,
 			"err", err,
 		)
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// Measures and tag keys recorded by the services.
+var (
+	// KeyOutcome is the final outcome of a delivery, either "success" or "failure".
+	KeyOutcome = tag.MustNewKey("outcome")
+	// KeyBackend is the backend of a delivery, e.g. "webex".
+	KeyBackend = tag.MustNewKey("backend")
+	// KeyQueue is the name of a delivery queue.
+	KeyQueue = tag.MustNewKey("queue")
+	// KeyLimit is the limit of the rate limiter, either "token" or "room".
+	KeyLimit = tag.MustNewKey("limit")
+
+	// MeasurePostAttempts is the number of attempts made to deliver one message.
+	MeasurePostAttempts = stats.Int64(
+		"webexteams/post_attempts",
+		"Number of attempts made to deliver a message to Webex",
+		stats.UnitDimensionless,
+	)
+
+	// MeasureQueueLength is the number of messages waiting in a delivery queue.
+	MeasureQueueLength = stats.Int64(
+		"webexteams/queue_length",
+		"Number of messages waiting in the delivery queue",
+		stats.UnitDimensionless,
+	)
+
+	// MeasureQueueDropped counts queued messages that were dropped because Webex rejected them.
+	MeasureQueueDropped = stats.Int64(
+		"webexteams/queue_dropped",
+		"Number of queued messages dropped because they could not be delivered",
+		stats.UnitDimensionless,
+	)
+
+	// MeasureSuppressed counts duplicate notifications dropped by the dedup middleware.
+	MeasureSuppressed = stats.Int64(
+		"webexteams/notifications_suppressed",
+		"Number of duplicate notifications suppressed",
+		stats.UnitDimensionless,
+	)
+
+	// MeasureRateLimitWait is the time a message waited for the rate limiter.
+	MeasureRateLimitWait = stats.Float64(
+		"webexteams/rate_limit_wait",
+		"Time a message waited for the rate limiter",
+		stats.UnitMilliseconds,
+	)
+
+	// MeasureRateLimitExceeded counts messages rejected because they would have waited too long for the rate limiter.
+	MeasureRateLimitExceeded = stats.Int64(
+		"webexteams/rate_limit_exceeded",
+		"Number of messages rejected by the rate limiter",
+		stats.UnitDimensionless,
+	)
+
+	// MeasureRateLimitBuckets is the number of token buckets of the rate limiter which are not full.
+	MeasureRateLimitBuckets = stats.Int64(
+		"webexteams/rate_limit_buckets",
+		"Number of rate limiter token buckets that are not full",
+		stats.UnitDimensionless,
+	)
+
+	// MeasureTokenRefreshes counts the refreshes of OAuth access tokens.
+	MeasureTokenRefreshes = stats.Int64(
+		"webexteams/token_refreshes",
+		"Number of OAuth access token refreshes",
+		stats.UnitDimensionless,
+	)
+)
+
+func outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const queueFileSuffix = ".json"
+
+// ErrQueueEmpty is returned by FileQueue.Peek if there is no queued item.
+var ErrQueueEmpty = errors.New("queue is empty")
+
+// FileQueue is a durable FIFO queue storing each item as a separate file in a directory.
+// Items are named by an increasing sequence number, so they survive restarts in order.
+type FileQueue struct {
+	dir string
+	mu  sync.Mutex
+	seq uint64
+}
+
+// NewFileQueue opens the queue stored in dir, creating the directory if needed.
+func NewFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+	q := &FileQueue{dir: dir}
+	names, err := q.names()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 {
+		last := strings.TrimSuffix(names[len(names)-1], queueFileSuffix)
+		q.seq, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid queue item %s: %w", last, err)
+		}
+	}
+	return q, nil
+}
+
+// Dir returns the directory of the queue.
+func (q *FileQueue) Dir() string {
+	return q.dir
+}
+
+// Push appends an item to the queue. The item is synced to disk before Push returns.
+func (q *FileQueue) Push(b []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	name := fmt.Sprintf("%020d%s", q.seq, queueFileSuffix)
+	tmp := filepath.Join(q.dir, "."+name)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create queue item: %w", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write queue item: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync queue item: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close queue item: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(q.dir, name))
+}
+
+// Peek returns the oldest item of the queue and its id without removing it.
+func (q *FileQueue) Peek() (string, []byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	names, err := q.names()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(names) == 0 {
+		return "", nil, ErrQueueEmpty
+	}
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, names[0]))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read queue item: %w", err)
+	}
+	return names[0], b, nil
+}
+
+// Remove deletes the item with the given id from the queue.
+func (q *FileQueue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return os.Remove(filepath.Join(q.dir, filepath.Base(id)))
+}
+
+// Len returns the number of queued items.
+func (q *FileQueue) Len() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	names, err := q.names()
+	return len(names), err
+}
+
+// names returns the sorted file names of all queued items.
+func (q *FileQueue) names() ([]string, error) {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+	var names []string
+	for _, f := range files {
+		n := f.Name()
+		if f.IsDir() || strings.HasPrefix(n, ".") || !strings.HasSuffix(n, queueFileSuffix) {
+			continue
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (q *FileQueue) recordLength(ctx context.Context) {
+	n, err := q.Len()
+	if err != nil {
+		return
+	}
+	_ = stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{tag.Upsert(KeyQueue, filepath.Base(q.dir))},
+		MeasureQueueLength.M(int64(n)),
+	)
+}
+
+// Replayer is implemented by services that keep undelivered messages for a later delivery.
+type Replayer interface {
+	// Replay delivers the kept messages in order.
+	// It stops at the first message that cannot be delivered yet.
+	Replay(ctx context.Context) error
+}
+
+// RunReplayer calls Replay on the replayers returned by the given function every interval until ctx is done.
+// The function is called on every run, so the set of replayers may change over time.
+func RunReplayer(ctx context.Context, logger log.Logger, interval time.Duration, replayers func() []Replayer) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		for _, r := range replayers() {
+			if err := r.Replay(ctx); err != nil {
+				logger.Log("err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFileQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []string{"1", "2"} {
+		if err := q.Push([]byte(item)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reopening the queue must keep the order of the stored items.
+	q, err = NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push([]byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"1", "2", "3"} {
+		id, b, err := q.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Fatalf("want item %s, got %s", want, b)
+		}
+		if err := q.Remove(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := q.Peek(); !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("want ErrQueueEmpty, got %v", err)
+	}
+}
+
+func Test_simpleService_queue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := NewFileQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	available := false
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(503)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(b))
+	}))
+	defer srv.Close()
+
+	s := simpleService{
+		sender: sender{
+			client:   srv.Client(),
+			tokens:   StaticToken("token"),
+			retry:    RetryPolicy{MaxAttempts: 1}.WithDefaults(),
+			failures: DefaultFailureMapping,
+		},
+		webhookURL: srv.URL,
+		queue:      q,
+	}
+
+	ctx := context.Background()
+	for _, body := range []string{"first", "second"} {
+		pr, err := s.sendOrEnqueue(ctx, delivery{method: http.MethodPost, url: srv.URL, body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pr.Status != http.StatusAccepted {
+			t.Fatalf("want status %d, got %d", http.StatusAccepted, pr.Status)
+		}
+	}
+
+	if err := s.Replay(ctx); err == nil {
+		t.Fatal("want an error while webex is unavailable")
+	}
+
+	available = true
+	if err := s.Replay(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[0] != "first" || received[1] != "second" {
+		t.Fatalf("unexpected replayed messages %v", received)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Fatalf("want an empty queue, got %d items", n)
+	}
+
+	// With an empty queue messages are delivered directly.
+	pr, err := s.sendOrEnqueue(ctx, delivery{method: http.MethodPost, url: srv.URL, body: "third"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Status != 200 {
+		t.Fatalf("want status 200, got %d", pr.Status)
+	}
+}
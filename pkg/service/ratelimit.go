@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// ErrRateLimited is returned if a message would have to wait longer than allowed for the rate limiter.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Limits of the RateLimiter, used as value of KeyLimit.
+const (
+	limitToken = "token"
+	limitRoom  = "room"
+)
+
+// RateLimit is the rate of a token bucket.
+type RateLimit struct {
+	// Rate is the number of messages per second, unlimited if 0.
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of messages that may be sent at once.
+	Burst int `yaml:"burst"`
+}
+
+// RateLimiter limits the messages sent to Webex with token buckets,
+// one per access token and one per room or person.
+// Messages exceeding the rate wait until the buckets allow them.
+type RateLimiter struct {
+	perToken RateLimit
+	perRoom  RateLimit
+	maxWait  time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter creates a RateLimiter.
+// Messages which would have to wait longer than maxWait are rejected with ErrRateLimited.
+func NewRateLimiter(perToken RateLimit, perRoom RateLimit, maxWait time.Duration) *RateLimiter {
+	return &RateLimiter{
+		perToken: perToken,
+		perRoom:  perRoom,
+		maxWait:  maxWait,
+		now:      time.Now,
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+// Wait blocks until a message may be sent with the access token to the target.
+func (l *RateLimiter) Wait(ctx context.Context, accessToken string, t Target) error {
+	if l == nil {
+		return nil
+	}
+
+	delay, limit, err := l.reserve(accessToken, t)
+	if err != nil {
+		_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(KeyLimit, limit)}, MeasureRateLimitExceeded.M(1))
+		return err
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	_ = stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{tag.Upsert(KeyLimit, limit)},
+		MeasureRateLimitWait.M(float64(delay)/float64(time.Millisecond)),
+	)
+	trace.FromContext(ctx).Annotate(
+		[]trace.Attribute{
+			trace.StringAttribute("limit", limit),
+			trace.StringAttribute("wait", delay.String()),
+		},
+		"waiting for rate limiter",
+	)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token from the buckets of the access token and the target
+// and returns how long to wait for it, together with the limit causing the wait.
+func (l *RateLimiter) reserve(accessToken string, t Target) (time.Duration, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var (
+		delay    time.Duration
+		limit    string
+		reserved []*tokenBucket
+	)
+	for _, r := range []struct {
+		limit string
+		key   string
+		rate  RateLimit
+	}{
+		{limitToken, tokenKey(accessToken), l.perToken},
+		{limitRoom, t.String(), l.perRoom},
+	} {
+		if r.rate.Rate <= 0 {
+			continue
+		}
+		b := l.bucket(r.limit+":"+r.key, r.rate)
+		d := b.reserve(now)
+		reserved = append(reserved, b)
+		if d > delay || limit == "" {
+			delay, limit = d, r.limit
+		}
+	}
+
+	if delay > l.maxWait {
+		for _, b := range reserved {
+			b.cancel()
+		}
+		return 0, limit, fmt.Errorf("%w: %s limit requires waiting %s", ErrRateLimited, limit, delay.Round(time.Millisecond))
+	}
+	l.gc(now)
+	return delay, limit, nil
+}
+
+func (l *RateLimiter) bucket(key string, rate RateLimit) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(rate, l.now())
+		l.buckets[key] = b
+		stats.Record(context.Background(), MeasureRateLimitBuckets.M(int64(len(l.buckets))))
+	}
+	return b
+}
+
+// gc removes the buckets which are full again, they are the same as new ones.
+func (l *RateLimiter) gc(now time.Time) {
+	for k, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, k)
+		}
+	}
+	stats.Record(context.Background(), MeasureRateLimitBuckets.M(int64(len(l.buckets))))
+}
+
+// tokenKey identifies an access token without keeping it in memory.
+func tokenKey(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(sum[:8])
+}
+
+// tokenBucket holds up to burst tokens and is refilled with rate tokens per second.
+// The tokens may become negative, which is the debt of the waiting messages.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate RateLimit, now time.Time) *tokenBucket {
+	burst := float64(rate.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate.Rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// reserve takes a token and returns the time until it is available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.advance(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token.
+func (b *tokenBucket) cancel() {
+	b.tokens++
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.advance(now)
+	return b.tokens >= b.burst
+}
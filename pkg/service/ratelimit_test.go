@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_reserve(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewRateLimiter(RateLimit{Rate: 2, Burst: 4}, RateLimit{Rate: 1, Burst: 2}, 1500*time.Millisecond)
+	l.now = func() time.Time { return now }
+
+	a, b := Target{RoomID: "a"}, Target{RoomID: "b"}
+	steps := []struct {
+		target    Target
+		wantDelay time.Duration
+		wantLimit string
+		wantErr   bool
+	}{
+		{a, 0, limitToken, false},
+		{a, 0, limitToken, false},
+		{a, time.Second, limitRoom, false},
+		// The room bucket would have to wait 2s, the reservation is returned.
+		{a, 0, limitRoom, true},
+		{b, 0, limitToken, false},
+		// The burst of the token bucket was used up by the messages to a and b.
+		{b, 500 * time.Millisecond, limitToken, false},
+	}
+	for i, step := range steps {
+		delay, limit, err := l.reserve("token", step.target)
+		if (err != nil) != step.wantErr || (err != nil && !errors.Is(err, ErrRateLimited)) {
+			t.Fatalf("step %d: unexpected error %v", i, err)
+		}
+		if delay != step.wantDelay || limit != step.wantLimit {
+			t.Errorf("step %d: want delay %s by %s limit, got %s by %s", i, step.wantDelay, step.wantLimit, delay, limit)
+		}
+	}
+
+	// After refilling, the buckets are removed.
+	now = now.Add(time.Minute)
+	if _, _, err := l.reserve("other", Target{RoomID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("want only the buckets of the last message, got %d", len(l.buckets))
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/infonova/prometheus-webexteams/resources"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// RequestTemplate renders the Webex request sending a card to a recipient.
+// The template defines "teams.request", see resources/webex-teams-request.tmpl.
+type RequestTemplate struct {
+	tmpl *template.Template
+}
+
+// DefaultRequestTemplate is the built-in request template.
+var DefaultRequestTemplate = MustParseRequestTemplate(resources.RequestTemplate)
+
+// ParseRequestTemplate parses a request template.
+// Besides the Go template functions, the functions of the Alertmanager templates are available.
+func ParseRequestTemplate(text string) (*RequestTemplate, error) {
+	tmpl, err := template.New("").
+		Option("missingkey=zero").
+		Funcs(template.FuncMap(amtemplate.DefaultFuncs)).
+		Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request template: %w", err)
+	}
+	if tmpl.Lookup("teams.request") == nil {
+		return nil, fmt.Errorf("the request template does not define 'teams.request'")
+	}
+	return &RequestTemplate{tmpl}, nil
+}
+
+// ParseRequestTemplateFile parses a request template from the given file.
+func ParseRequestTemplateFile(f string) (*RequestTemplate, error) {
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseRequestTemplate(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f, err)
+	}
+	return t, nil
+}
+
+// MustParseRequestTemplate is like ParseRequestTemplate but panics if the template cannot be parsed.
+func MustParseRequestTemplate(text string) *RequestTemplate {
+	t, err := ParseRequestTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// execute renders the request. The template is safe for concurrent use.
+func (t *RequestTemplate) execute(data requestData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.ExecuteTemplate(&buf, "teams.request", data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
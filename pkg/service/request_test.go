@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRequestTemplate(t *testing.T) {
+	tests := []struct {
+		name string
+		data requestData
+		want map[string]interface{}
+	}{
+		{
+			name: "room",
+			data: requestData{RoomId: "room", Card: `{"type":"AdaptiveCard"}`},
+			want: map[string]interface{}{"roomId": "room"},
+		},
+		{
+			name: "person email reply",
+			data: requestData{ToPersonEmail: "jane@example.com", ParentId: "parent", Card: `{"type":"AdaptiveCard"}`},
+			want: map[string]interface{}{"toPersonEmail": "jane@example.com", "parentId": "parent"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DefaultRequestTemplate.execute(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var req map[string]interface{}
+			if err := json.Unmarshal([]byte(got), &req); err != nil {
+				t.Fatalf("invalid request %s: %v", got, err)
+			}
+			for k, v := range tt.want {
+				if req[k] != v {
+					t.Errorf("want %s %v, got %v", k, v, req[k])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRequestTemplate(t *testing.T) {
+	if _, err := ParseRequestTemplate(`{{ define "other" }}{}{{ end }}`); err == nil {
+		t.Fatal("want an error for a template not defining teams.request")
+	}
+	tmpl, err := ParseRequestTemplate(`{{ define "teams.request" }}{"roomId": "{{ .RoomId | toUpper }}"}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tmpl.execute(requestData{RoomId: "room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"roomId": "ROOM"}`; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
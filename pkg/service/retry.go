@@ -0,0 +1,92 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how failed Webex API requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialInterval is the wait time before the first retry.
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	// MaxInterval caps the wait time between two attempts.
+	// A Retry-After header asking for a longer wait stops the retries.
+	MaxInterval time.Duration `yaml:"max_interval"`
+	// Multiplier is applied to the interval after each attempt.
+	Multiplier float64 `yaml:"multiplier"`
+	// Jitter randomizes each interval by +/- the given fraction (0..1).
+	Jitter float64 `yaml:"jitter"`
+}
+
+// DefaultRetryPolicy is used for every field left empty in a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	InitialInterval: 1 * time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+// WithDefaults returns a copy of p where unset fields are taken from DefaultRetryPolicy.
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = DefaultRetryPolicy.InitialInterval
+	}
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = DefaultRetryPolicy.MaxInterval
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	return p
+}
+
+// backoff returns the wait time after the given (1-based) failed attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	d += d * p.Jitter * (2*rand.Float64() - 1) //nolint: gosec
+	return time.Duration(d)
+}
+
+// retryable reports whether a request that ended with the given status code or error should be tried again.
+func retryable(status int, err error) bool {
+	if err != nil {
+		return true
+	}
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
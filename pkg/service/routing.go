@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/alertmanager/template"
+	"go.opencensus.io/trace"
+)
+
+// RoutingRule sends the alerts whose labels match all of its matchers to its own rooms and persons.
+type RoutingRule struct {
+	Matchers []*labels.Matcher
+	Targets  []Target
+	// Converter renders the card of the matching alerts. The service's converter is used if nil.
+	Converter card.Converter
+	// Continue evaluates the following rules as well if the rule matched.
+	Continue bool
+}
+
+// ParseMatchers parses label matchers given in the Alertmanager label-matcher syntax,
+// e.g. `team="payments"` or `{severity=~"critical|warning",env!="dev"}`.
+func ParseMatchers(ss []string) ([]*labels.Matcher, error) {
+	var matchers []*labels.Matcher
+	for _, s := range ss {
+		ms, err := labels.ParseMatchers(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %s: %w", s, err)
+		}
+		matchers = append(matchers, ms...)
+	}
+	return matchers, nil
+}
+
+func (r RoutingRule) matches(a template.Alert) bool {
+	for _, m := range r.Matchers {
+		if !m.Matches(a.Labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// routedMessage is the part of a webhook message sent to the same recipients with the same converter.
+type routedMessage struct {
+	message   webhook.Message
+	targets   []Target
+	converter card.Converter
+}
+
+// route splits the alerts of the message by the routing rules they match.
+// Alerts matching no rule are sent to the default targets.
+// The alerts naming recipients are additionally sent to each of them.
+func (s simpleService) route(ctx context.Context, wm webhook.Message) []routedMessage {
+	if wm.Data == nil {
+		return []routedMessage{{wm, s.targets, s.converter}}
+	}
+	return append(s.routeByRules(wm), s.routeToRecipients(ctx, wm)...)
+}
+
+func (s simpleService) routeByRules(wm webhook.Message) []routedMessage {
+	if len(s.rules) == 0 {
+		if len(s.targets) == 0 {
+			return nil
+		}
+		return []routedMessage{{wm, s.targets, s.converter}}
+	}
+
+	// Index len(s.rules) collects the alerts of the default rooms.
+	alerts := make([][]template.Alert, len(s.rules)+1)
+	for _, a := range wm.Alerts {
+		matched := false
+		for i, r := range s.rules {
+			if !r.matches(a) {
+				continue
+			}
+			matched = true
+			alerts[i] = append(alerts[i], a)
+			if !r.Continue {
+				break
+			}
+		}
+		if !matched {
+			alerts[len(s.rules)] = append(alerts[len(s.rules)], a)
+		}
+	}
+
+	var routed []routedMessage
+	for i, as := range alerts {
+		if len(as) == 0 {
+			continue
+		}
+		rm := routedMessage{subMessage(wm, as), s.targets, s.converter}
+		if i < len(s.rules) {
+			rm.targets = s.rules[i].Targets
+			if s.rules[i].Converter != nil {
+				rm.converter = s.rules[i].Converter
+			}
+		}
+		if len(rm.targets) > 0 {
+			routed = append(routed, rm)
+		}
+	}
+	return routed
+}
+
+// routeToRecipients sends every recipient named by the alerts a message holding its alerts.
+func (s simpleService) routeToRecipients(ctx context.Context, wm webhook.Message) []routedMessage {
+	var (
+		targets []Target
+		alerts  = map[Target][]template.Alert{}
+	)
+	for _, a := range wm.Alerts {
+		ts, errs := s.recipients.of(a)
+		for _, err := range errs {
+			trace.FromContext(ctx).Annotate(
+				[]trace.Attribute{trace.StringAttribute("err", err.Error())},
+				"ignoring invalid recipient",
+			)
+		}
+		for _, t := range ts {
+			if _, ok := alerts[t]; !ok {
+				targets = append(targets, t)
+			}
+			alerts[t] = append(alerts[t], a)
+		}
+	}
+
+	routed := make([]routedMessage, 0, len(targets))
+	for _, t := range targets {
+		routed = append(routed, routedMessage{subMessage(wm, alerts[t]), []Target{t}, s.converter})
+	}
+	return routed
+}
+
+// subMessage returns a copy of the message holding only the given alerts.
+// The status and the common labels and annotations are recomputed for these alerts.
+func subMessage(wm webhook.Message, alerts []template.Alert) webhook.Message {
+	if wm.Data == nil || len(alerts) == len(wm.Alerts) {
+		return wm
+	}
+	return withAlerts(wm, alerts)
+}
+
+// withAlerts returns a copy of the message holding the given alerts, which must not be empty.
+// The status and the common labels and annotations are computed for these alerts.
+func withAlerts(wm webhook.Message, alerts []template.Alert) webhook.Message {
+	data := template.Data{}
+	if wm.Data != nil {
+		data = *wm.Data
+	}
+	data.Alerts = alerts
+	data.Status = "resolved"
+	data.CommonLabels = template.KV{}
+	data.CommonAnnotations = template.KV{}
+	for k, v := range alerts[0].Labels {
+		data.CommonLabels[k] = v
+	}
+	for k, v := range alerts[0].Annotations {
+		data.CommonAnnotations[k] = v
+	}
+	for _, a := range alerts {
+		if a.Status == "firing" {
+			data.Status = "firing"
+		}
+		for k, v := range data.CommonLabels {
+			if a.Labels[k] != v {
+				delete(data.CommonLabels, k)
+			}
+		}
+		for k, v := range data.CommonAnnotations {
+			if a.Annotations[k] != v {
+				delete(data.CommonAnnotations, k)
+			}
+		}
+	}
+	wm.Data = &data
+	return wm
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+func Test_simpleService_route(t *testing.T) {
+	mustParse := func(ss ...string) RoutingRule {
+		ms, err := ParseMatchers(ss)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return RoutingRule{Matchers: ms}
+	}
+	payments := mustParse(`team="payments"`)
+	payments.Targets = RoomTargets("payments")
+	critical := mustParse(`{severity=~"critical|page"}`)
+	critical.Targets = RoomTargets("oncall")
+	critical.Continue = true
+	criticalAgain := mustParse(`severity="critical"`, `team!="payments"`)
+	criticalAgain.Targets = RoomTargets("escalation")
+
+	alert := func(status string, kv ...string) template.Alert {
+		a := template.Alert{Status: status, Labels: template.KV{}}
+		for i := 0; i < len(kv); i += 2 {
+			a.Labels[kv[i]] = kv[i+1]
+		}
+		return a
+	}
+	wm := webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			alert("firing", "team", "payments", "severity", "critical"),
+			alert("resolved", "team", "search", "severity", "critical"),
+			alert("firing", "team", "search", "severity", "warning"),
+		},
+		CommonLabels: template.KV{},
+	}}
+
+	tests := []struct {
+		name    string
+		rooms   []string
+		rules   []RoutingRule
+		want    map[string][]int
+		wantLen int
+	}{
+		{
+			name:  "no rules",
+			rooms: []string{"default"},
+			want:  map[string][]int{"default": {0, 1, 2}},
+		},
+		{
+			name:  "first match wins, unmatched to default",
+			rooms: []string{"default"},
+			rules: []RoutingRule{payments, critical, criticalAgain},
+			want: map[string][]int{
+				"payments":   {0},
+				"oncall":     {1},
+				"escalation": {1},
+				"default":    {2},
+			},
+		},
+		{
+			name:  "unmatched dropped without default rooms",
+			rules: []RoutingRule{payments},
+			want:  map[string][]int{"payments": {0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := simpleService{targets: RoomTargets(tt.rooms...), rules: tt.rules}
+			got := map[string][]int{}
+			for _, rm := range s.route(context.Background(), wm) {
+				for _, a := range rm.message.Alerts {
+					for i, want := range wm.Alerts {
+						if reflect.DeepEqual(a, want) {
+							for _, target := range rm.targets {
+								got[target.RoomID] = append(got[target.RoomID], i)
+							}
+						}
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("route() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_subMessage(t *testing.T) {
+	wm := webhook.Message{Data: &template.Data{
+		Status: "firing",
+		Alerts: template.Alerts{
+			{Status: "firing", Labels: template.KV{"alertname": "A", "team": "x"}},
+			{Status: "resolved", Labels: template.KV{"alertname": "A", "team": "y"}},
+			{Status: "resolved", Labels: template.KV{"alertname": "A", "team": "y", "pod": "p"}},
+		},
+		CommonLabels: template.KV{"alertname": "A"},
+	}}
+
+	got := subMessage(wm, wm.Alerts[1:])
+	if got.Status != "resolved" {
+		t.Errorf("want status resolved, got %s", got.Status)
+	}
+	if want := (template.KV{"alertname": "A", "team": "y"}); !reflect.DeepEqual(got.CommonLabels, want) {
+		t.Errorf("want common labels %v, got %v", want, got.CommonLabels)
+	}
+	if len(wm.Alerts) != 3 || len(wm.CommonLabels) != 1 {
+		t.Errorf("original message was modified")
+	}
+}
+
+func Test_simpleService_routeToRecipients(t *testing.T) {
+	wm := webhook.Message{Data: &template.Data{
+		Alerts: template.Alerts{
+			{Status: "firing", Annotations: template.KV{"owner_email": "jane@example.com"}},
+			{Status: "firing", Annotations: template.KV{"owner_email": `jane@example.com, "bob"@x`}},
+			{Status: "firing", Labels: template.KV{"owner": "Y2lzY29zcGFyazovL3VzL1BFT1BMRS8x"}},
+			{Status: "firing"},
+		},
+	}}
+	s := simpleService{
+		targets:    RoomTargets("room"),
+		recipients: recipientSources{labels: []string{"owner"}, annotations: []string{"owner_email"}},
+	}
+
+	got := map[Target]int{}
+	for _, rm := range s.route(context.Background(), wm) {
+		for _, target := range rm.targets {
+			got[target] = len(rm.message.Alerts)
+		}
+	}
+	want := map[Target]int{
+		{RoomID: "room"}:                               4,
+		{PersonEmail: "jane@example.com"}:              2,
+		{PersonID: "Y2lzY29zcGFyazovL3VzL1BFT1BMRS8x"}: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("route() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// sender sends requests to a backend, retrying failed ones according to the retry policy.
+// Deliveries that still fail are reported as DeliveryError using the failure mapping.
+// It is shared by the services of all backends.
+type sender struct {
+	backend  Backend
+	client   *http.Client
+	retry    RetryPolicy
+	failures FailureMapping
+	// tokens authorize the requests with a bearer token, the requests are not authorized if nil.
+	tokens TokenSource
+	// headers are added to the requests, replacing the default headers of the same name.
+	headers http.Header
+}
+
+// postWithRetry posts the request body to url until it succeeds or the retry policy is exhausted.
+func (s sender) postWithRetry(ctx context.Context, url string, body string) (PostResponse, error) {
+	return s.sendWithRetry(ctx, http.MethodPost, url, body)
+}
+
+// sendWithRetry sends the request until it succeeds or the retry policy is exhausted.
+// The returned PostResponse holds the outcome of the last attempt.
+func (s sender) sendWithRetry(ctx context.Context, method string, url string, body string) (pr PostResponse, err error) {
+	ctx, span := trace.StartSpan(ctx, "sender.sendWithRetry")
+	defer span.End()
+
+	defer func() {
+		_ = stats.RecordWithTags(
+			ctx,
+			[]tag.Mutator{
+				tag.Upsert(KeyOutcome, outcome(err)),
+				tag.Upsert(KeyBackend, string(s.backend.WithDefaults())),
+			},
+			MeasurePostAttempts.M(int64(pr.Attempts)),
+		)
+	}()
+
+	reauthorized := false
+	for attempt := 1; ; attempt++ {
+		var token string
+		if s.tokens != nil {
+			token, err = s.tokens.Token(ctx)
+			if err != nil {
+				pr = PostResponse{WebhookURL: url, Message: err.Error(), Attempts: attempt - 1}
+				return pr, s.deliveryError(pr, fmt.Errorf("failed to get access token: %w", err))
+			}
+		}
+
+		var retryAfter time.Duration
+		pr, retryAfter, err = s.do(ctx, method, url, token, body)
+		pr.Attempts = attempt
+		if err == nil && pr.Status < 400 {
+			return pr, nil
+		}
+		if err == nil && pr.Status == http.StatusUnauthorized && !reauthorized && s.tokens != nil && s.tokens.Invalidate(token) {
+			// The token expired or was revoked, try once more with a new one right away.
+			reauthorized = true
+			span.Annotate(nil, "access token rejected, retrying with a new token")
+			continue
+		}
+		retry := retryable(pr.Status, err)
+		if err == nil {
+			err = fmt.Errorf("%s api responded with status %d", s.backend.WithDefaults(), pr.Status)
+		}
+		if attempt >= s.retry.MaxAttempts || !retry {
+			return pr, s.deliveryError(pr, err)
+		}
+
+		wait := s.retry.backoff(attempt)
+		if retryAfter > s.retry.MaxInterval {
+			err = fmt.Errorf("%w: retry-after %s exceeds the max retry interval", err, retryAfter)
+			return pr, s.deliveryError(pr, err)
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		span.Annotate(
+			[]trace.Attribute{
+				trace.Int64Attribute("attempt", int64(attempt)),
+				trace.StringAttribute("wait", wait.String()),
+			},
+			"retrying request",
+		)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			err = fmt.Errorf("%w: giving up retries: %v", err, ctx.Err())
+			return pr, s.deliveryError(pr, err)
+		case <-t.C:
+		}
+	}
+}
+
+// deliveryError reports a failed delivery with the status code mapped by the FailureMapping.
+func (s sender) deliveryError(pr PostResponse, err error) error {
+	return &DeliveryError{Status: s.failures.status(pr.Status), Err: err}
+}
+
+// do sends a single request and returns the response together with the wait time requested by a Retry-After header.
+func (s sender) do(ctx context.Context, method string, url string, token string, body string) (PostResponse, time.Duration, error) {
+	pr := PostResponse{WebhookURL: url}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return pr, 0, fmt.Errorf("failed to create http request: %w", err)
+	}
+	// add authorization header to the request
+	if s.tokens != nil {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range s.headers {
+		req.Header.Del(name)
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("http client failed: %w", err)
+		pr.Message = err.Error()
+		return pr, 0, err
+	}
+	defer resp.Body.Close()
+
+	pr.Status = resp.StatusCode
+	retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("failed reading http response body: %w", err)
+		pr.Message = err.Error()
+		return pr, retryAfter, err
+	}
+	pr.Message = string(rb)
+	if pr.Status < 400 {
+		pr.MessageID = parseMessage(pr.Message).ID
+	}
+
+	return pr, retryAfter, nil
+}
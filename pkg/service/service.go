@@ -2,13 +2,20 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/infonova/prometheus-webexteams/pkg/card"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/alertmanager/notify/webhook"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 )
 
@@ -17,6 +24,13 @@ type PostResponse struct {
 	WebhookURL string `json:"webhook_url"`
 	Status     int    `json:"status"`
 	Message    string `json:"message"`
+	Attempts   int    `json:"attempts"`
+	// MessageID is the ID of the Webex message created.
+	MessageID string `json:"message_id,omitempty"`
+	// Target is the recipient of the message.
+	Target
+	// Results holds the response of each recipient if a message was sent to several recipients.
+	Results []PostResponse `json:"results,omitempty"`
 }
 
 // Service is the Alertmanager to Webex Teams webhook service.
@@ -25,81 +39,480 @@ type Service interface {
 }
 
 type simpleService struct {
-	converter   card.Converter
-	client      *http.Client
-	webhookURL  string
-	accessToken string
-	roomId      string
+	sender
+	converter  card.Converter
+	webhookURL string
+	targets    []Target
+	rules      []RoutingRule
+	recipients recipientSources
+	queue      *FileQueue
+	threads    *ThreadStore
+	onResolve  ResolveAction
+	split      SplitPolicy
+	limiter    *RateLimiter
+	request    *RequestTemplate
 }
 
 type requestData struct {
-	RoomId string
-	Card   string
+	RoomId        string
+	ToPersonId    string
+	ToPersonEmail string
+	ParentId      string
+	Card          string
+}
+
+// Options configures a simpleService.
+type Options struct {
+	WebhookURL  string
+	AccessToken string
+	// Tokens provides the access tokens, e.g. refreshed OAuth tokens. The static AccessToken is used if nil.
+	Tokens TokenSource
+	// Targets are the rooms and persons receiving the alerts not matched by any of the Rules.
+	Targets []Target
+	// Rules route the alerts to rooms by their labels, the first matching rule wins
+	// unless it is marked to continue.
+	Rules []RoutingRule
+	// RecipientLabels are the alert labels holding additional recipients of the alert,
+	// either the email or the ID of a person.
+	RecipientLabels []string
+	// RecipientAnnotations are the alert annotations holding additional recipients of the alert,
+	// e.g. owner_email.
+	RecipientAnnotations []string
+	// Retry is the retry policy of failed requests.
+	Retry RetryPolicy
+	// Failures maps failed deliveries to the status codes returned to Alertmanager.
+	Failures FailureMapping
+	// Queue keeps the messages that could not be delivered because Webex is unavailable, if not nil.
+	Queue *FileQueue
+	// Threads remembers the first message of every alert group, if not nil.
+	// The following notifications of the group are posted as replies in its thread.
+	Threads *ThreadStore
+	// OnResolve is what happens with the first message of a group when the group resolves,
+	// ResolvePost if empty. It requires Threads.
+	OnResolve ResolveAction
+	// Split is how alert groups producing too large cards are sent.
+	Split SplitPolicy
+	// Limiter limits the rate of the messages sent to Webex, if not nil.
+	// It may be shared by several services.
+	Limiter *RateLimiter
+	// RequestTemplate renders the request sending a card, DefaultRequestTemplate if nil.
+	RequestTemplate *RequestTemplate
 }
 
 // NewSimpleService creates a simpleService.
-func NewSimpleService(converter card.Converter, client *http.Client, webhookURL string, accessToken string, roomId string) Service {
-	return simpleService{converter, client, webhookURL, accessToken, roomId}
+// The alerts of every message are routed by the routing rules and sent to their rooms and persons concurrently.
+// Alerts naming a person in one of the recipient labels or annotations are sent to that person as well.
+// If a queue is given, messages that could not be delivered because Webex is unavailable
+// are kept in the queue and delivered later by Replay.
+// If a thread store is given, the notifications of an alert group are threaded under its first message.
+// Requests rejected with 401 Unauthorized are sent once more with a new token, if the token source can refresh it.
+func NewSimpleService(converter card.Converter, client *http.Client, opts Options) Service {
+	tokens := opts.Tokens
+	if tokens == nil {
+		tokens = StaticToken(opts.AccessToken)
+	}
+	request := opts.RequestTemplate
+	if request == nil {
+		request = DefaultRequestTemplate
+	}
+	return simpleService{
+		sender: sender{
+			backend:  BackendWebex,
+			client:   client,
+			retry:    opts.Retry.WithDefaults(),
+			failures: opts.Failures.WithDefaults(),
+			tokens:   tokens,
+		},
+		converter:  converter,
+		webhookURL: opts.WebhookURL,
+		targets:    opts.Targets,
+		rules:      opts.Rules,
+		recipients: recipientSources{opts.RecipientLabels, opts.RecipientAnnotations},
+		queue:      opts.Queue,
+		threads:    opts.Threads,
+		onResolve:  opts.OnResolve,
+		split:      opts.Split.WithDefaults(),
+		limiter:    opts.Limiter,
+		request:    request,
+	}
+}
+
+// queuedRequest is a rendered Webex request kept in the delivery queue.
+type queuedRequest struct {
+	Body     string    `json:"body"`
+	QueuedAt time.Time `json:"queued_at"`
+	// ThreadKey is the thread started by the message, if any.
+	ThreadKey string `json:"thread_key,omitempty"`
+	// Method and URL of requests deleting a message.
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// Target is the recipient of the message.
+	Target Target `json:"target"`
 }
 
 func (s simpleService) Post(ctx context.Context, wm webhook.Message) (PostResponse, error) {
 	ctx, span := trace.StartSpan(ctx, "simpleService.Post")
 	defer span.End()
 
-	c, err := s.converter.Convert(ctx, wm)
-	if err != nil {
-		return PostResponse{}, fmt.Errorf("failed to parse webhook message: %w", err)
+	// The request bodies are rendered up front, since templates must not be parsed concurrently.
+	// pages holds the deliveries of every routed message to every target, in page order.
+	var (
+		pages [][]delivery
+		n     int
+	)
+	for _, rm := range s.route(ctx, wm) {
+		cards, err := s.convert(ctx, rm)
+		if err != nil {
+			return PostResponse{}, fmt.Errorf("failed to parse webhook message: %w", err)
+		}
+		for _, t := range rm.targets {
+			var ds []delivery
+			d := s.thread(rm.message, t)
+			for i, c := range cards {
+				if i > 0 {
+					// Further pages are replies in the thread of the group, if it has one.
+					if d.method == http.MethodDelete {
+						break
+					}
+					d = delivery{target: t, parentID: d.threadID, threadID: d.threadID}
+				}
+				if d.method != http.MethodDelete {
+					d.card = c
+					if d.body, err = s.requestBody(c, d.target, d.parentID); err != nil {
+						return PostResponse{WebhookURL: s.webhookURL, Target: t}, err
+					}
+				}
+				ds = append(ds, d)
+			}
+			pages = append(pages, ds)
+			n += len(ds)
+		}
+	}
+
+	switch n {
+	case 0:
+		span.Annotate(nil, "no recipient matches the alerts")
+		return PostResponse{WebhookURL: s.webhookURL, Status: http.StatusOK, Message: "no recipient matches the alerts"}, nil
+	case 1:
+		return s.post(ctx, pages[0][0])
 	}
+	return s.postAll(ctx, pages)
+}
 
-	pr, err := s.post(ctx, c, s.webhookURL)
+// delivery is a rendered Webex request for a recipient.
+type delivery struct {
+	target Target
+	// method and url of the request, a POST to the webhook URL if empty.
+	method string
+	url    string
+	body   string
+	// card is the card in the body, which is rendered again if the parent of the request changes.
+	card string
+	// parentID is the message the request replies to.
+	parentID string
+	// threadID is the first message of the thread of the group, if any.
+	threadID string
+	// startThread and endThread are the keys of the thread started or ended by the request.
+	startThread string
+	endThread   string
+}
 
+// thread returns the delivery of the message to the target, replying to the thread of its alert group.
+// A firing group without a thread starts one, a resolved group ends its thread
+// and replies to it or deletes its first message as configured.
+func (s simpleService) thread(wm webhook.Message, t Target) delivery {
+	d := delivery{target: t}
+	if s.threads == nil || wm.Data == nil || wm.GroupKey == "" {
+		return d
+	}
+	key := threadKey(wm.GroupKey, t)
+	m, ok := s.threads.Get(key)
+	if !ok {
+		if wm.Status != "resolved" {
+			d.startThread = key
+		}
+		return d
+	}
+	d.threadID = m.ID
+	if wm.Status != "resolved" {
+		d.parentID = m.ID
+		return d
+	}
+
+	d.endThread = key
+	if s.onResolve == ResolveDelete {
+		d.method = http.MethodDelete
+		d.url = s.messageURL(m.ID)
+	} else {
+		d.parentID = m.ID
+	}
+	return d
+}
+
+// messageURL returns the URL of a Webex message.
+func (s simpleService) messageURL(id string) string {
+	return strings.TrimSuffix(s.webhookURL, "/") + "/" + url.PathEscape(id)
+}
+
+// postAll sends the pages of the targets concurrently, the pages of each target one after another.
+func (s simpleService) postAll(ctx context.Context, pages [][]delivery) (PostResponse, error) {
+	var (
+		results = make([][]PostResponse, len(pages))
+		errs    = make([][]error, len(pages))
+		wg      sync.WaitGroup
+	)
+	for i, ds := range pages {
+		wg.Add(1)
+		go func(i int, ds []delivery) {
+			defer wg.Done()
+			results[i], errs[i] = s.postPages(ctx, ds)
+		}(i, ds)
+	}
+	wg.Wait()
+
+	var (
+		allResults []PostResponse
+		allErrs    []error
+	)
+	for i := range pages {
+		allResults = append(allResults, results[i]...)
+		allErrs = append(allErrs, errs[i]...)
+	}
+	return s.aggregate(allResults, allErrs)
+}
+
+// postPages sends the pages of a message to a target in page order.
+// If the group has no thread, the following pages reply to the first page, so the pages stay together.
+// Otherwise all pages reply to the thread, since Webex does not accept replies to replies.
+// The pages following a failed one are not sent, since they would arrive out of order.
+func (s simpleService) postPages(ctx context.Context, ds []delivery) ([]PostResponse, []error) {
+	var (
+		results []PostResponse
+		errs    []error
+		firstID string
+	)
+	for i, d := range ds {
+		if i > 0 && d.threadID == "" && firstID != "" {
+			d.parentID = firstID
+			var err error
+			if d.body, err = s.requestBody(d.card, d.target, d.parentID); err != nil {
+				return append(results, PostResponse{WebhookURL: s.webhookURL, Target: d.target}), append(errs, err)
+			}
+		}
+		pr, err := s.post(ctx, d)
+		results = append(results, pr)
+		errs = append(errs, err)
+		if err != nil {
+			break
+		}
+		if i == 0 {
+			firstID = pr.MessageID
+		}
+	}
+	return results, errs
+}
+
+// aggregate combines the responses of several recipients into one PostResponse.
+// The delivery fails if it failed for any of the recipients, with the highest status code of the failures.
+func (s simpleService) aggregate(results []PostResponse, errs []error) (PostResponse, error) {
+	pr := PostResponse{WebhookURL: s.webhookURL, Results: results}
+
+	var (
+		failed []string
+		status int
+	)
+	for i, r := range results {
+		if r.Status > pr.Status {
+			pr.Status = r.Status
+		}
+		if r.Attempts > pr.Attempts {
+			pr.Attempts = r.Attempts
+		}
+		if errs[i] == nil {
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", r.Target, errs[i]))
+		st := http.StatusInternalServerError
+		var de *DeliveryError
+		if errors.As(errs[i], &de) {
+			st = de.Status
+		}
+		if st > status {
+			status = st
+		}
+	}
+	pr.Message = fmt.Sprintf("delivered to %d of %d recipients", len(results)-len(failed), len(results))
+
+	if len(failed) > 0 {
+		return pr, &DeliveryError{
+			Status: status,
+			Err:    fmt.Errorf("failed to deliver to %d of %d recipients: %s", len(failed), len(results), strings.Join(failed, "; ")),
+		}
+	}
 	return pr, nil
 }
 
-func (s simpleService) post(ctx context.Context, c string, url string) (PostResponse, error) {
+// requestBody renders the Webex request sending the card to a recipient.
+func (s simpleService) requestBody(c string, t Target, parentID string) (string, error) {
+	data := requestData{
+		RoomId:        t.RoomID,
+		ToPersonId:    t.PersonID,
+		ToPersonEmail: t.PersonEmail,
+		ParentId:      parentID,
+		Card:          c,
+	}
+	reqStr, err := s.request.execute(data)
+	if err != nil {
+		return "", fmt.Errorf("execute 'message.request' template failed: %w", err)
+	}
+	return reqStr, nil
+}
+
+// post sends the request to its recipient and keeps track of the thread of the message.
+func (s simpleService) post(ctx context.Context, d delivery) (pr PostResponse, err error) {
 	ctx, span := trace.StartSpan(ctx, "simpleService.post")
 	defer span.End()
 
-	pr := PostResponse{WebhookURL: url}
+	if d.method == "" {
+		d.method = http.MethodPost
+	}
+	if d.url == "" {
+		d.url = s.webhookURL
+	}
 
-	data := requestData{
-		RoomId: s.roomId,
-		Card:   c,
+	if s.queue == nil {
+		pr, err = s.sendLimited(ctx, d.target, d.method, d.url, d.body)
+	} else {
+		pr, err = s.sendOrEnqueue(ctx, d)
 	}
-	tmpl, err := card.ParseTemplateFile("./resources/webex-teams-request.tmpl")
+	pr.Target = d.target
 	if err != nil {
-		err = fmt.Errorf("load 'message.request' template failed: %w", err)
 		return pr, err
 	}
 
-	var reqStr string
-	reqStr, err = tmpl.ExecuteTextString(`{{ template "teams.request" . }}`, data)
-	if err != nil {
-		err = fmt.Errorf("execute 'message.request' template failed: %w", err)
-		return pr, err
+	if d.endThread != "" {
+		s.threads.Delete(d.endThread)
 	}
+	if d.startThread != "" {
+		if m := parseMessage(pr.Message); m.ID != "" {
+			s.threads.Set(d.startThread, m)
+		}
+	}
+	return pr, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", s.webhookURL, strings.NewReader(reqStr))
-	// add authorization header to the request
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.accessToken))
-	req.Header.Set("Content-Type", "application/json")
+// sendOrEnqueue delivers the request or keeps it in the queue if Webex is unavailable.
+// While older messages are waiting in the queue, new ones are queued right away to keep their order.
+func (s simpleService) sendOrEnqueue(ctx context.Context, d delivery) (PostResponse, error) {
+	ctx, span := trace.StartSpan(ctx, "simpleService.sendOrEnqueue")
+	defer span.End()
 
-	resp, err := s.client.Do(req)
+	url := d.url
+	n, err := s.queue.Len()
 	if err != nil {
-		err = fmt.Errorf("http client failed: %w", err)
-		return pr, err
+		return PostResponse{WebhookURL: url}, err
 	}
-	defer resp.Body.Close()
 
-	pr.Status = resp.StatusCode
+	var pr PostResponse
+	if n == 0 {
+		pr, err = s.sendLimited(ctx, d.target, d.method, url, d.body)
+		if err == nil || !transient(pr, err) {
+			return pr, err
+		}
+	}
 
-	rb, err := ioutil.ReadAll(resp.Body)
+	qr := queuedRequest{Body: d.body, QueuedAt: time.Now(), ThreadKey: d.startThread, Target: d.target}
+	if d.method != http.MethodPost {
+		qr.Method, qr.URL = d.method, url
+	}
+	b, err := json.Marshal(qr)
 	if err != nil {
-		err = fmt.Errorf("failed reading http response body: %w", err)
-		pr.Message = err.Error()
-		return pr, err
+		return pr, fmt.Errorf("failed to encode queued message: %w", err)
+	}
+	if err := s.queue.Push(b); err != nil {
+		return pr, s.deliveryError(pr, fmt.Errorf("failed to queue message: %w", err))
 	}
-	pr.Message = string(rb)
+	s.queue.recordLength(ctx)
+	span.Annotate(nil, "message queued")
 
-	return pr, nil
+	return PostResponse{
+		WebhookURL: url,
+		Status:     http.StatusAccepted,
+		Message:    "queued for delivery",
+		Attempts:   pr.Attempts,
+	}, nil
+}
+
+// Replay implements Replayer.
+// Queued messages rejected by Webex with a client error are dropped, since they would never succeed.
+func (s simpleService) Replay(ctx context.Context) error {
+	if s.queue == nil {
+		return nil
+	}
+	ctx, span := trace.StartSpan(ctx, "simpleService.Replay")
+	defer span.End()
+	defer s.queue.recordLength(ctx)
+
+	for {
+		id, b, err := s.queue.Peek()
+		if errors.Is(err, ErrQueueEmpty) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var qr queuedRequest
+		if err := json.Unmarshal(b, &qr); err != nil {
+			return s.drop(ctx, id, fmt.Errorf("invalid queued message: %w", err))
+		}
+
+		method, url := http.MethodPost, s.webhookURL
+		if qr.Method != "" {
+			method, url = qr.Method, qr.URL
+		}
+		pr, err := s.sendLimited(ctx, qr.Target, method, url, qr.Body)
+		if err != nil {
+			if transient(pr, err) {
+				return fmt.Errorf("failed to replay queued message %s: %w", id, err)
+			}
+			return s.drop(ctx, id, err)
+		}
+		if err := s.queue.Remove(id); err != nil {
+			return fmt.Errorf("failed to remove delivered message %s from queue: %w", id, err)
+		}
+		if m := parseMessage(pr.Message); qr.ThreadKey != "" && s.threads != nil && m.ID != "" {
+			if _, ok := s.threads.Get(qr.ThreadKey); !ok {
+				s.threads.Set(qr.ThreadKey, m)
+			}
+		}
+	}
+}
+
+func (s simpleService) drop(ctx context.Context, id string, cause error) error {
+	if err := s.queue.Remove(id); err != nil {
+		return fmt.Errorf("failed to remove message %s from queue: %w", id, err)
+	}
+	_ = stats.RecordWithTags(
+		ctx,
+		[]tag.Mutator{tag.Upsert(KeyQueue, filepath.Base(s.queue.Dir()))},
+		MeasureQueueDropped.M(1),
+	)
+	return fmt.Errorf("dropped queued message %s: %w", id, cause)
+}
+
+// transient reports whether a failed delivery may succeed later.
+func transient(pr PostResponse, err error) bool {
+	return err != nil && (pr.Status == 0 || retryable(pr.Status, nil))
+}
+
+// sendLimited sends the request as soon as the rate limiter allows it.
+// Requests rejected by the rate limiter fail like requests rejected by Webex with 429 Too Many Requests,
+// without a status, so they are queued if a queue is configured.
+func (s simpleService) sendLimited(ctx context.Context, t Target, method string, url string, body string) (PostResponse, error) {
+	if err := s.limiter.Wait(ctx, s.tokens.Key(), t); err != nil {
+		pr := PostResponse{WebhookURL: url, Message: err.Error()}
+		return pr, &DeliveryError{Status: s.failures.status(http.StatusTooManyRequests), Err: err}
+	}
+	return s.sendWithRetry(ctx, method, url, body)
 }
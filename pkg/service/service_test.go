@@ -1,133 +1,199 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
-	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/alertmanager/notify/webhook"
 )
 
-const longActivityTitle = "1111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111"
+type staticConverter string
 
-func Test_splitOffice365Card(t *testing.T) {
+func (c staticConverter) Convert(context.Context, webhook.Message) (string, error) {
+	return string(c), nil
+}
+
+func Test_sender_postWithRetry(t *testing.T) {
 	tests := []struct {
-		name string
-		c    card.Office365ConnectorCard
-		want []card.Office365ConnectorCard
+		name         string
+		statuses     []int
+		retryAfter   string
+		wantAttempts int
+		wantStatus   int
+		wantErr      bool
 	}{
 		{
-			name: "no split required",
-			c: card.Office365ConnectorCard{
-				Context:  "http://schema.org/extensions",
-				Type:     "MessageCard",
-				Sections: []card.Section{{ActivityTitle: "1"}},
-			},
-			want: []card.Office365ConnectorCard{
-				{
-					Context:  "http://schema.org/extensions",
-					Type:     "MessageCard",
-					Sections: []card.Section{{ActivityTitle: "1"}},
-				},
-			},
+			name:         "success without retry",
+			statuses:     []int{200},
+			wantAttempts: 1,
+			wantStatus:   200,
 		},
 		{
-			name: "too many sections must be splitted",
-			c: card.Office365ConnectorCard{
-				Context: "http://schema.org/extensions",
-				Type:    "MessageCard",
-				Sections: []card.Section{
-					{ActivityTitle: "1"},
-					{ActivityTitle: "2"},
-					{ActivityTitle: "3"},
-					{ActivityTitle: "4"},
-					{ActivityTitle: "5"},
-					{ActivityTitle: "6"},
-					{ActivityTitle: "7"},
-					{ActivityTitle: "8"},
-					{ActivityTitle: "9"},
-					{ActivityTitle: "10"},
-					{ActivityTitle: "11"},
-					{ActivityTitle: "12"},
-					{ActivityTitle: "13"},
-					{ActivityTitle: "14"},
-					{ActivityTitle: "15"},
-					{ActivityTitle: "16"},
-					{ActivityTitle: "17"},
-					{ActivityTitle: "18"},
-					{ActivityTitle: "19"},
-					{ActivityTitle: "20"},
-				},
-			},
-			want: []card.Office365ConnectorCard{
-				{
-					Context: "http://schema.org/extensions",
-					Type:    "MessageCard",
-					Sections: []card.Section{
-						{ActivityTitle: "1"},
-						{ActivityTitle: "2"},
-						{ActivityTitle: "3"},
-						{ActivityTitle: "4"},
-						{ActivityTitle: "5"},
-						{ActivityTitle: "6"},
-						{ActivityTitle: "7"},
-						{ActivityTitle: "8"},
-						{ActivityTitle: "9"},
-						{ActivityTitle: "10"},
-					},
-				},
-				{
-					Context: "http://schema.org/extensions",
-					Type:    "MessageCard",
-					Sections: []card.Section{
-						{ActivityTitle: "11"},
-						{ActivityTitle: "12"},
-						{ActivityTitle: "13"},
-						{ActivityTitle: "14"},
-						{ActivityTitle: "15"},
-						{ActivityTitle: "16"},
-						{ActivityTitle: "17"},
-						{ActivityTitle: "18"},
-						{ActivityTitle: "19"},
-						{ActivityTitle: "20"},
-					},
-				},
-			},
+			name:         "retry on rate limiting and server errors",
+			statuses:     []int{429, 503, 200},
+			retryAfter:   "0",
+			wantAttempts: 3,
+			wantStatus:   200,
 		},
 		{
-			name: "too large alerts",
-			c: card.Office365ConnectorCard{
-				Context: "http://schema.org/extensions",
-				Type:    "MessageCard",
-				Sections: []card.Section{
-					{ActivityTitle: longActivityTitle},
-					{ActivityTitle: "2"},
-				},
-			},
-			want: []card.Office365ConnectorCard{
-				{
-					Context:  "http://schema.org/extensions",
-					Type:     "MessageCard",
-					Sections: []card.Section{{ActivityTitle: longActivityTitle}},
-				},
-				{
-					Context:  "http://schema.org/extensions",
-					Type:     "MessageCard",
-					Sections: []card.Section{{ActivityTitle: "2"}},
-				},
-			},
+			name:         "give up after max attempts",
+			statuses:     []int{500, 500, 500, 500},
+			wantAttempts: 3,
+			wantStatus:   500,
+			wantErr:      true,
+		},
+		{
+			name:         "no retry on client errors",
+			statuses:     []int{400, 200},
+			wantAttempts: 1,
+			wantStatus:   400,
+			wantErr:      true,
+		},
+		{
+			name:         "retry-after longer than max interval stops retries",
+			statuses:     []int{429, 200},
+			retryAfter:   "3600",
+			wantAttempts: 1,
+			wantStatus:   429,
+			wantErr:      true,
 		},
 	}
 
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := splitOffice365Card(tt.c)
-			if err != nil {
-				t.Fatal(err)
+			var calls int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "Bearer token" {
+					t.Errorf("unexpected authorization header %q", got)
+				}
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer srv.Close()
+
+			s := sender{
+				client: srv.Client(),
+				tokens: StaticToken("token"),
+				retry: RetryPolicy{
+					MaxAttempts:     3,
+					InitialInterval: time.Millisecond,
+					MaxInterval:     10 * time.Millisecond,
+				}.WithDefaults(),
+				failures: DefaultFailureMapping,
 			}
 
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Fatalf("mismatch (-want +got):\n%s", diff)
+			pr, err := s.postWithRetry(context.Background(), srv.URL, "{}")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr %v, got %v", tt.wantErr, err)
+			}
+			var de *DeliveryError
+			if tt.wantErr && !errors.As(err, &de) {
+				t.Fatalf("want a DeliveryError, got %T", err)
+			}
+			if pr.Attempts != tt.wantAttempts {
+				t.Errorf("want %d attempts, got %d", tt.wantAttempts, pr.Attempts)
+			}
+			if pr.Status != tt.wantStatus {
+				t.Errorf("want status %d, got %d", tt.wantStatus, pr.Status)
 			}
 		})
 	}
 }
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Wed, 01 Jan 2020 00:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 31 Dec 2019 23:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.in, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; want %s, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFailureMapping_status(t *testing.T) {
+	m := FailureMapping{ServerErrorStatus: 500}.WithDefaults()
+	tests := []struct {
+		webexStatus int
+		want        int
+	}{
+		{0, 503},
+		{400, 400},
+		{401, 400},
+		{429, 500},
+		{502, 500},
+	}
+	for _, tt := range tests {
+		if got := m.status(tt.webexStatus); got != tt.want {
+			t.Errorf("status(%d) = %d, want %d", tt.webexStatus, got, tt.want)
+		}
+	}
+}
+
+func Test_simpleService_Post_rooms(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		var req struct {
+			RoomID string `json:"roomId"`
+		}
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Errorf("invalid request body: %v", err)
+		}
+		if req.RoomID == "broken" {
+			w.WriteHeader(404)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewSimpleService(
+		staticConverter(`{"type": "AdaptiveCard"}`),
+		srv.Client(),
+		Options{
+			WebhookURL:  srv.URL,
+			AccessToken: "token",
+			Targets:     RoomTargets("a", "broken", "b"),
+			Retry:       RetryPolicy{MaxAttempts: 1},
+		},
+	)
+
+	pr, err := s.Post(context.Background(), webhook.Message{})
+	var de *DeliveryError
+	if !errors.As(err, &de) || de.Status != 400 {
+		t.Fatalf("want a DeliveryError with status 400, got %v", err)
+	}
+	if len(pr.Results) != 3 {
+		t.Fatalf("want 3 results, got %d", len(pr.Results))
+	}
+	for i, want := range []struct {
+		room   string
+		status int
+	}{{"a", 200}, {"broken", 404}, {"b", 200}} {
+		if pr.Results[i].RoomID != want.room || pr.Results[i].Status != want.status {
+			t.Errorf("result %d: want room %s with status %d, got %+v", i, want.room, want.status, pr.Results[i])
+		}
+	}
+	if pr.Message != "delivered to 2 of 3 recipients" {
+		t.Errorf("unexpected message %q", pr.Message)
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"go.opencensus.io/trace"
+)
+
+// Split modes of oversized cards.
+const (
+	// SplitPaginate sends the alerts in several messages, numbered "1/4", "2/4", ...
+	SplitPaginate = "paginate"
+	// SplitTruncate sends the alerts fitting into one message and a "+N more alerts" summary.
+	SplitTruncate = "truncate"
+	// SplitNone sends the card as it is, Webex rejects it if it is too large.
+	SplitNone = "none"
+)
+
+// SplitPolicy configures how alert groups producing cards larger than the Webex API accepts are sent.
+type SplitPolicy struct {
+	// Mode is one of SplitPaginate, SplitTruncate or SplitNone.
+	Mode string `yaml:"mode"`
+	// MaxCardSize is the maximum size of a card in bytes.
+	MaxCardSize int `yaml:"max_card_size"`
+}
+
+// DefaultSplitPolicy is used for every field left empty in a SplitPolicy.
+// Webex rejects cards larger than 28 KB.
+var DefaultSplitPolicy = SplitPolicy{
+	Mode:        SplitPaginate,
+	MaxCardSize: 28 * 1024,
+}
+
+// WithDefaults returns a copy of p where unset fields are taken from DefaultSplitPolicy.
+func (p SplitPolicy) WithDefaults() SplitPolicy {
+	if p.Mode == "" {
+		p.Mode = DefaultSplitPolicy.Mode
+	}
+	if p.MaxCardSize <= 0 {
+		p.MaxCardSize = DefaultSplitPolicy.MaxCardSize
+	}
+	return p
+}
+
+// Validate checks the mode of the policy.
+func (p SplitPolicy) Validate() error {
+	switch p.Mode {
+	case "", SplitPaginate, SplitTruncate, SplitNone:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %s, valid modes are %s, %s, %s", p.Mode, SplitPaginate, SplitTruncate, SplitNone)
+}
+
+// convert converts the routed message to one card, or to several if the card is too large.
+func (s simpleService) convert(ctx context.Context, rm routedMessage) ([]string, error) {
+	c, err := rm.converter.Convert(ctx, rm.message)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) <= s.split.MaxCardSize || s.split.Mode == SplitNone || rm.message.Data == nil || len(rm.message.Alerts) < 2 {
+		return []string{c}, nil
+	}
+
+	span := trace.FromContext(ctx)
+	span.Annotate(
+		[]trace.Attribute{
+			trace.Int64Attribute("size", int64(len(c))),
+			trace.StringAttribute("mode", s.split.Mode),
+		},
+		"card too large, splitting alerts",
+	)
+	if s.split.Mode == SplitTruncate {
+		return s.truncate(ctx, rm)
+	}
+	return s.paginate(ctx, rm, len(c))
+}
+
+// paginate spreads the alerts evenly across the fewest pages whose cards are small enough.
+func (s simpleService) paginate(ctx context.Context, rm routedMessage, size int) ([]string, error) {
+	alerts := rm.message.Alerts
+	start := size/s.split.MaxCardSize + 1
+	if start > len(alerts) {
+		start = len(alerts)
+	}
+	var cards []string
+	for pages := start; pages <= len(alerts); pages++ {
+		cards = cards[:0]
+		fits := true
+		for i := 0; i < pages; i++ {
+			lo, hi := i*len(alerts)/pages, (i+1)*len(alerts)/pages
+			c, err := rm.converter.Convert(ctx, subMessage(rm.message, alerts[lo:hi]))
+			if err != nil {
+				return nil, err
+			}
+			if c, err = card.AppendTextBlock(c, fmt.Sprintf("Page %d/%d", i+1, pages)); err != nil {
+				return nil, err
+			}
+			cards = append(cards, c)
+			if len(c) > s.split.MaxCardSize && hi-lo > 1 {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return cards, nil
+		}
+	}
+	return cards, nil
+}
+
+// truncate sends the most alerts whose card is small enough, followed by the number of the left out ones.
+func (s simpleService) truncate(ctx context.Context, rm routedMessage) ([]string, error) {
+	alerts := rm.message.Alerts
+	render := func(n int) (string, error) {
+		c, err := rm.converter.Convert(ctx, subMessage(rm.message, alerts[:n]))
+		if err != nil {
+			return "", err
+		}
+		return card.AppendTextBlock(c, fmt.Sprintf("+%d more alerts", len(alerts)-n))
+	}
+
+	// Binary search for the largest number of alerts fitting into the card, at least one.
+	best, err := render(1)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi := 2, len(alerts)-1
+	for lo <= hi {
+		n := (lo + hi) / 2
+		c, err := render(n)
+		if err != nil {
+			return nil, err
+		}
+		if len(c) > s.split.MaxCardSize {
+			hi = n - 1
+			continue
+		}
+		best = c
+		lo = n + 1
+	}
+	return []string{best}, nil
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// alertsConverter creates a card with a TextBlock of 100 characters per alert.
+type alertsConverter struct{}
+
+func (alertsConverter) Convert(_ context.Context, wm webhook.Message) (string, error) {
+	c := card.NewAdaptiveCard()
+	for range wm.Alerts {
+		c.Body = append(c.Body, card.NewTextBlock(strings.Repeat("x", 100)))
+	}
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+func Test_simpleService_convert(t *testing.T) {
+	wm := webhook.Message{Data: &template.Data{Alerts: make(template.Alerts, 40)}}
+	alerts := func(cards []string) int {
+		n := 0
+		for _, c := range cards {
+			n += strings.Count(c, strings.Repeat("x", 100))
+		}
+		return n
+	}
+
+	tests := []struct {
+		name      string
+		split     SplitPolicy
+		wantCards int
+		wantText  func(cards []string) string
+	}{
+		{
+			name:      "small enough",
+			wantCards: 1,
+		},
+		{
+			name:      "not split",
+			split:     SplitPolicy{Mode: SplitNone, MaxCardSize: 1000},
+			wantCards: 1,
+		},
+		{
+			name:      "paginate",
+			split:     SplitPolicy{Mode: SplitPaginate, MaxCardSize: 1500},
+			wantCards: 5,
+			wantText:  func([]string) string { return "Page 1/5" },
+		},
+		{
+			name:      "truncate",
+			split:     SplitPolicy{Mode: SplitTruncate, MaxCardSize: 1500},
+			wantCards: 1,
+			wantText:  func(cards []string) string { return fmt.Sprintf("+%d more alerts", 40-alerts(cards)) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := simpleService{split: tt.split.WithDefaults()}
+			cards, err := s.convert(context.Background(), routedMessage{message: wm, converter: alertsConverter{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cards) != tt.wantCards {
+				t.Fatalf("want %d cards, got %d", tt.wantCards, len(cards))
+			}
+			if tt.wantText != nil && !strings.Contains(cards[0], tt.wantText(cards)) {
+				t.Errorf("want %q in the card, got %s", tt.wantText(cards), cards[0])
+			}
+			if tt.split.Mode == SplitNone {
+				return
+			}
+			for i, c := range cards {
+				if len(c) > s.split.MaxCardSize {
+					t.Errorf("card %d has %d bytes", i, len(c))
+				}
+			}
+			if tt.split.Mode == SplitPaginate && alerts(cards) != 40 {
+				t.Errorf("want all 40 alerts on the pages, got %d", alerts(cards))
+			}
+		})
+	}
+}
+
+func Test_simpleService_Post_pages(t *testing.T) {
+	tests := []struct {
+		name string
+		// threadID is the first message of the group, if the group has a thread.
+		threadID string
+	}{
+		{name: "new group"},
+		{name: "existing thread", threadID: "thread"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			type request struct {
+				page     string
+				parentID string
+			}
+			var (
+				mu       sync.Mutex
+				requests = map[string][]request{}
+				firstIDs = map[string]string{}
+				page     = regexp.MustCompile(`Page \d+/\d+`)
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				var req struct {
+					RoomID   string `json:"roomId"`
+					ParentID string `json:"parentId"`
+				}
+				if err := json.Unmarshal(b, &req); err != nil {
+					t.Errorf("invalid request body: %v", err)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				requests[req.RoomID] = append(requests[req.RoomID], request{page.FindString(string(b)), req.ParentID})
+				id := fmt.Sprintf("%s-%d", req.RoomID, len(requests[req.RoomID]))
+				if len(requests[req.RoomID]) == 1 {
+					firstIDs[req.RoomID] = id
+				}
+				fmt.Fprintf(w, `{"id": %q}`, id)
+			}))
+			defer srv.Close()
+
+			targets := RoomTargets("a", "b")
+			threads := NewThreadStore(time.Hour)
+			if tt.threadID != "" {
+				for _, target := range targets {
+					threads.Set(threadKey("g", target), ThreadMessage{ID: tt.threadID})
+				}
+			}
+			s := NewSimpleService(alertsConverter{}, srv.Client(), Options{
+				WebhookURL:  srv.URL,
+				AccessToken: "token",
+				Targets:     targets,
+				Split:       SplitPolicy{Mode: SplitPaginate, MaxCardSize: 1500},
+				Threads:     threads,
+			})
+
+			wm := webhook.Message{Data: &template.Data{Status: "firing", Alerts: make(template.Alerts, 40)}, GroupKey: "g"}
+			if _, err := s.Post(context.Background(), wm); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, room := range []string{"a", "b"} {
+				if len(requests[room]) != 5 {
+					t.Fatalf("room %s: want 5 pages, got %d", room, len(requests[room]))
+				}
+				for i, r := range requests[room] {
+					if want := fmt.Sprintf("Page %d/5", i+1); r.page != want {
+						t.Errorf("room %s: want %s as message %d, got %s", room, want, i+1, r.page)
+					}
+					// The pages reply to the thread, or to the first page if there is none.
+					want := tt.threadID
+					if want == "" && i > 0 {
+						want = firstIDs[room]
+					}
+					if r.parentID != want {
+						t.Errorf("room %s: want page %d to reply to %q, got %q", room, i+1, want, r.parentID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_simpleService_convert_escapeUnderscores(t *testing.T) {
+	tmpl, err := card.ParseTemplate(`{{ define "teams.card" }}
+{"type": "AdaptiveCard", "version": "1.2", "body": [
+{{- range $i, $a := .Alerts }}{{ if $i }},{{ end }}{"type": "TextBlock", "text": "{{ $a.Labels.name }} {{ $a.Annotations.text }}"}{{ end -}}
+]}
+{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := make(template.Alerts, 20)
+	for i := range alerts {
+		alerts[i].Labels = template.KV{"name": "a_b"}
+		alerts[i].Annotations = template.KV{"text": strings.Repeat("x", 100)}
+	}
+	wm := webhook.Message{Data: &template.Data{Alerts: alerts}}
+
+	s := simpleService{split: SplitPolicy{Mode: SplitPaginate, MaxCardSize: 1000}.WithDefaults()}
+	rm := routedMessage{message: wm, converter: card.NewTemplatedCardCreator(tmpl, true)}
+	// Every conversion must escape the labels once, no matter how often the message was converted before.
+	for i := 0; i < 2; i++ {
+		cards, err := s.convert(context.Background(), rm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cards) < 2 {
+			t.Fatalf("want several pages, got %d", len(cards))
+		}
+		for p, c := range cards {
+			var got struct {
+				Body []struct {
+					Text string `json:"text"`
+				} `json:"body"`
+			}
+			if err := json.Unmarshal([]byte(c), &got); err != nil {
+				t.Fatalf("page %d is invalid: %v", p+1, err)
+			}
+			for _, tb := range got.Body {
+				if strings.HasPrefix(tb.Text, "Page ") {
+					continue
+				}
+				if !strings.HasPrefix(tb.Text, `a\_b `) {
+					t.Errorf("conversion %d, page %d: want the label escaped once, got %q", i+1, p+1, tb.Text)
+				}
+			}
+		}
+	}
+	if got := alerts[0].Labels["name"]; got != "a_b" {
+		t.Errorf("want the labels of the message unchanged, got %q", got)
+	}
+}
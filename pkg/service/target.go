@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/alertmanager/template"
+)
+
+// Target is the recipient of a Webex message, either a room or a person.
+// Exactly one of its fields is set.
+type Target struct {
+	RoomID      string `json:"room_id,omitempty"`
+	PersonID    string `json:"person_id,omitempty"`
+	PersonEmail string `json:"person_email,omitempty"`
+}
+
+// RoomTargets returns the targets of the given rooms.
+func RoomTargets(roomIds ...string) []Target {
+	return targets(roomIds, func(id string) Target { return Target{RoomID: id} })
+}
+
+// PersonTargets returns the direct message targets of the persons with the given IDs.
+func PersonTargets(personIds ...string) []Target {
+	return targets(personIds, func(id string) Target { return Target{PersonID: id} })
+}
+
+// EmailTargets returns the direct message targets of the persons with the given emails.
+func EmailTargets(emails ...string) []Target {
+	return targets(emails, func(email string) Target { return Target{PersonEmail: email} })
+}
+
+func targets(ids []string, target func(string) Target) []Target {
+	var ts []Target
+	for _, id := range ids {
+		if id != "" {
+			ts = append(ts, target(id))
+		}
+	}
+	return ts
+}
+
+func (t Target) String() string {
+	switch {
+	case t.PersonID != "":
+		return "person " + t.PersonID
+	case t.PersonEmail != "":
+		return "person " + t.PersonEmail
+	default:
+		return "room " + t.RoomID
+	}
+}
+
+// personIDPattern matches the IDs of Webex persons, which are base64 encoded.
+var personIDPattern = regexp.MustCompile(`^[A-Za-z0-9+/_=-]+$`)
+
+// ParseRecipient parses the value of a recipient label or annotation,
+// which is either the email or the ID of a person.
+func ParseRecipient(v string) (Target, error) {
+	v = strings.TrimSpace(v)
+	if strings.Contains(v, "@") {
+		addr, err := mail.ParseAddress(v)
+		if err != nil || addr.Address != v {
+			return Target{}, fmt.Errorf("invalid email %q", v)
+		}
+		return Target{PersonEmail: v}, nil
+	}
+	if !personIDPattern.MatchString(v) {
+		return Target{}, fmt.Errorf("invalid person id %q", v)
+	}
+	return Target{PersonID: v}, nil
+}
+
+// recipientSources are the labels and annotations naming additional recipients of an alert.
+type recipientSources struct {
+	labels      []string
+	annotations []string
+}
+
+// of returns the recipients named by the alert.
+// Several recipients may be given in one label or annotation, separated by commas.
+func (rs recipientSources) of(a template.Alert) ([]Target, []error) {
+	var values []string
+	for _, l := range rs.labels {
+		values = append(values, strings.Split(a.Labels[l], ",")...)
+	}
+	for _, an := range rs.annotations {
+		values = append(values, strings.Split(a.Annotations[an], ",")...)
+	}
+
+	var (
+		ts   []Target
+		errs []error
+	)
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		t, err := ParseRecipient(v)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ts = append(ts, t)
+	}
+	return ts, errs
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ThreadStore remembers the Webex message started for an alert group,
+// so the following notifications of the group are posted as replies to it.
+// Entries expire when the group was not notified within the TTL.
+type ThreadStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	threads map[string]thread
+}
+
+// ResolveAction is what happens with the first message of an alert group when the group resolves.
+// The message cannot be edited instead, since Webex does not allow editing messages with attachments.
+type ResolveAction string
+
+const (
+	// ResolvePost posts the resolved notification as reply in the thread of the group.
+	ResolvePost ResolveAction = "post"
+	// ResolveDelete deletes the first message.
+	ResolveDelete ResolveAction = "delete"
+)
+
+// ThreadMessage is the Webex message starting the thread of an alert group.
+type ThreadMessage struct {
+	ID string
+}
+
+type thread struct {
+	message ThreadMessage
+	expires time.Time
+}
+
+// NewThreadStore creates a ThreadStore keeping the threads for the given TTL.
+func NewThreadStore(ttl time.Duration) *ThreadStore {
+	return &ThreadStore{ttl: ttl, now: time.Now, threads: map[string]thread{}}
+}
+
+// TTL returns the time the threads are kept after their last notification.
+func (s *ThreadStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// Get returns the message starting the thread and extends its TTL.
+func (s *ThreadStore) Get(key string) (ThreadMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	t, ok := s.threads[key]
+	if !ok || now.After(t.expires) {
+		delete(s.threads, key)
+		return ThreadMessage{}, false
+	}
+	t.expires = now.Add(s.ttl)
+	s.threads[key] = t
+	return t.message, true
+}
+
+// Set stores the message starting the thread.
+func (s *ThreadStore) Set(key string, m ThreadMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, t := range s.threads {
+		if now.After(t.expires) {
+			delete(s.threads, k)
+		}
+	}
+	s.threads[key] = thread{message: m, expires: now.Add(s.ttl)}
+}
+
+// Delete ends the thread.
+func (s *ThreadStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.threads, key)
+}
+
+// threadKey identifies the thread of an alert group in a room or direct message.
+func threadKey(groupKey string, t Target) string {
+	return groupKey + "\x00" + t.String()
+}
+
+// parseMessage returns the message created by Webex, which is empty if the response holds none.
+func parseMessage(responseBody string) ThreadMessage {
+	var m struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(responseBody), &m); err != nil {
+		return ThreadMessage{}
+	}
+	return ThreadMessage{ID: m.ID}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+func TestThreadStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewThreadStore(time.Hour)
+	s.now = func() time.Time { return now }
+
+	s.Set("a", ThreadMessage{ID: "m1"})
+	now = now.Add(50 * time.Minute)
+	if m, ok := s.Get("a"); !ok || m.ID != "m1" {
+		t.Fatalf("want m1, got %q, %v", m.ID, ok)
+	}
+	// Get extends the TTL.
+	now = now.Add(50 * time.Minute)
+	if _, ok := s.Get("a"); !ok {
+		t.Fatal("want the thread to be kept after it was used")
+	}
+	now = now.Add(61 * time.Minute)
+	if _, ok := s.Get("a"); ok {
+		t.Fatal("want the thread to be expired")
+	}
+}
+
+func Test_simpleService_Post_threads(t *testing.T) {
+	tests := []struct {
+		onResolve ResolveAction
+		want      []string
+	}{
+		{
+			onResolve: "",
+			want:      []string{"POST / parent=", "POST / parent=m1", "POST / parent=m1", "POST / parent="},
+		},
+		{
+			onResolve: ResolveDelete,
+			want:      []string{"POST / parent=", "POST / parent=m1", "DELETE /m1 parent=", "POST / parent="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.onResolve), func(t *testing.T) {
+			var (
+				requests []string
+				n        int
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				var req struct {
+					ParentID string `json:"parentId"`
+				}
+				if r.Method != http.MethodDelete {
+					if err := json.Unmarshal(b, &req); err != nil {
+						t.Errorf("invalid request body: %v", err)
+					}
+				}
+				requests = append(requests, fmt.Sprintf("%s %s parent=%s", r.Method, r.URL.Path, req.ParentID))
+				if r.Method == http.MethodDelete {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				n++
+				fmt.Fprintf(w, `{"id": "m%d"}`, n)
+			}))
+			defer srv.Close()
+
+			s := NewSimpleService(
+				staticConverter(`{"type": "AdaptiveCard"}`),
+				srv.Client(),
+				Options{
+					WebhookURL:  srv.URL,
+					AccessToken: "token",
+					Targets:     RoomTargets("room"),
+					Threads:     NewThreadStore(time.Hour),
+					OnResolve:   tt.onResolve,
+				},
+			)
+
+			for _, status := range []string{"firing", "firing", "resolved", "firing"} {
+				wm := webhook.Message{Data: &template.Data{Status: status}, GroupKey: "{}:{alertname=\"A\"}"}
+				if _, err := s.Post(context.Background(), wm); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if fmt.Sprint(requests) != fmt.Sprint(tt.want) {
+				t.Errorf("want requests %q, got %q", tt.want, requests)
+			}
+		})
+	}
+}
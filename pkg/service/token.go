@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+// TokenSource provides the access token authorizing the Webex API requests.
+type TokenSource interface {
+	// Token returns a valid access token.
+	Token(ctx context.Context) (string, error)
+	// Invalidate discards the token after Webex rejected it as unauthorized,
+	// and reports whether a new token can be obtained.
+	Invalidate(token string) bool
+	// Key identifies the Webex identity of the tokens. It stays the same when the token is refreshed,
+	// so it is used to limit the rate of the messages.
+	Key() string
+}
+
+// StaticToken is a token that never expires, like the token of a Webex bot.
+type StaticToken string
+
+// Token implements TokenSource.
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Invalidate implements TokenSource, a static token cannot be replaced.
+func (t StaticToken) Invalidate(string) bool {
+	return false
+}
+
+// Key implements TokenSource.
+func (t StaticToken) Key() string {
+	return string(t)
+}
+
+// DefaultTokenURL is the Webex OAuth endpoint issuing access tokens.
+const DefaultTokenURL = "https://webexapis.com/v1/access_token"
+
+// OAuthConfig configures the refresh of the access tokens of a Webex integration.
+type OAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	// TokenURL is the OAuth token endpoint, DefaultTokenURL if empty.
+	TokenURL string
+	// RefreshBefore is the time before the expiry of the access token when it is refreshed,
+	// DefaultRefreshBefore if 0.
+	RefreshBefore time.Duration
+}
+
+// DefaultRefreshBefore is the default time before the expiry of an access token when it is refreshed.
+const DefaultRefreshBefore = time.Hour
+
+// RefreshTokenSource gets the access tokens of a Webex integration with the OAuth refresh token flow.
+// The access token is cached and refreshed before it expires.
+type RefreshTokenSource struct {
+	client *http.Client
+	config OAuthConfig
+	now    func() time.Time
+
+	mu           sync.Mutex
+	token        string
+	expiry       time.Time
+	refreshToken string
+}
+
+// WithDefaults returns a copy of c where unset fields are set to their defaults.
+func (c OAuthConfig) WithDefaults() OAuthConfig {
+	if c.TokenURL == "" {
+		c.TokenURL = DefaultTokenURL
+	}
+	if c.RefreshBefore <= 0 {
+		c.RefreshBefore = DefaultRefreshBefore
+	}
+	return c
+}
+
+// NewRefreshTokenSource creates a RefreshTokenSource.
+func NewRefreshTokenSource(client *http.Client, config OAuthConfig) *RefreshTokenSource {
+	config = config.WithDefaults()
+	return &RefreshTokenSource{
+		client:       client,
+		config:       config,
+		now:          time.Now,
+		refreshToken: config.RefreshToken,
+	}
+}
+
+// Config returns the config of the token source with the defaults applied.
+func (s *RefreshTokenSource) Config() OAuthConfig {
+	return s.config
+}
+
+// Token implements TokenSource.
+// The token is refreshed if there is none yet, or if it expires within the RefreshBefore time.
+func (s *RefreshTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || s.now().Add(s.config.RefreshBefore).Before(s.expiry)) {
+		return s.token, nil
+	}
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+	return s.token, nil
+}
+
+// Invalidate implements TokenSource.
+// Tokens that were already replaced are ignored, so concurrent requests rejected
+// with the same token refresh it only once.
+func (s *RefreshTokenSource) Invalidate(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+	return true
+}
+
+// Key implements TokenSource.
+func (s *RefreshTokenSource) Key() string {
+	return "oauth:" + s.config.ClientID + ":" + s.config.RefreshToken
+}
+
+// tokenResponse is the response of the Webex OAuth token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refresh gets a new access token with the refresh token. Webex may return a new refresh token too,
+// which replaces the current one. It must be called with the lock held.
+func (s *RefreshTokenSource) refresh(ctx context.Context) (err error) {
+	ctx, span := trace.StartSpan(ctx, "RefreshTokenSource.refresh")
+	defer span.End()
+
+	defer func() {
+		_ = stats.RecordWithTags(
+			ctx,
+			[]tag.Mutator{tag.Upsert(KeyOutcome, outcome(err))},
+			MeasureTokenRefreshes.M(1),
+		)
+	}()
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.config.ClientID},
+		"client_secret": {s.config.ClientSecret},
+		"refresh_token": {s.refreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to refresh access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading token response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to refresh access token: token endpoint responded with status %d: %s", resp.StatusCode, b)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(b, &tr); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return fmt.Errorf("invalid token response: no access_token")
+	}
+
+	s.token = tr.AccessToken
+	// A token without expiry is used until Webex rejects it.
+	s.expiry = time.Time{}
+	if tr.ExpiresIn > 0 {
+		s.expiry = s.now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	if tr.RefreshToken != "" {
+		s.refreshToken = tr.RefreshToken
+	}
+	return nil
+}
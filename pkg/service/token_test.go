@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenSource_Token(t *testing.T) {
+	var refreshes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "refresh_token" {
+			t.Errorf("unexpected grant_type %q", got)
+		}
+		if got, want := r.PostForm.Get("refresh_token"), fmt.Sprintf("refresh-%d", refreshes); got != want {
+			t.Errorf("want refresh_token %q, got %q", want, got)
+		}
+		refreshes++
+		fmt.Fprintf(w, `{"access_token":"access-%d","expires_in":7200,"refresh_token":"refresh-%d"}`, refreshes, refreshes)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	ts := NewRefreshTokenSource(srv.Client(), OAuthConfig{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RefreshToken: "refresh-0",
+		TokenURL:     srv.URL,
+	})
+	ts.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    string
+	}{
+		{name: "first token is refreshed", want: "access-1"},
+		{name: "cached token", advance: 30 * time.Minute, want: "access-1"},
+		{name: "refreshed before expiry", advance: 31 * time.Minute, want: "access-2"},
+	}
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		got, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got != s.want {
+			t.Errorf("%s: want token %q, got %q", s.name, s.want, got)
+		}
+	}
+	if refreshes != 2 {
+		t.Errorf("want 2 refreshes, got %d", refreshes)
+	}
+}
+
+func Test_sender_refreshOnUnauthorized(t *testing.T) {
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"new","expires_in":7200}`)
+	}))
+	defer tokenSrv.Close()
+
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	ts := NewRefreshTokenSource(tokenSrv.Client(), OAuthConfig{RefreshToken: "refresh", TokenURL: tokenSrv.URL})
+	ts.token = "revoked"
+	s := sender{
+		client:   srv.Client(),
+		tokens:   ts,
+		retry:    RetryPolicy{MaxAttempts: 1}.WithDefaults(),
+		failures: DefaultFailureMapping,
+	}
+
+	pr, err := s.postWithRetry(context.Background(), srv.URL, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Status != http.StatusOK {
+		t.Errorf("want status %d, got %d", http.StatusOK, pr.Status)
+	}
+	if want := []string{"Bearer revoked", "Bearer new"}; fmt.Sprint(auths) != fmt.Sprint(want) {
+		t.Errorf("want authorizations %v, got %v", want, auths)
+	}
+
+	// A static token is not retried.
+	auths = nil
+	s.tokens = StaticToken("static")
+	if _, err := s.postWithRetry(context.Background(), srv.URL, "{}"); err == nil {
+		t.Fatal("want an error for a rejected static token")
+	}
+	if len(auths) != 1 {
+		t.Errorf("want 1 request, got %d", len(auths))
+	}
+}
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Reasons of failed authentications, recorded with KeyReason.
+const (
+	authMissing = "missing"
+	authInvalid = "invalid"
+)
+
+// authError is returned by an Authenticator when the request is not authenticated.
+type authError struct {
+	reason string
+	msg    string
+}
+
+func (e *authError) Error() string { return e.msg }
+
+// Authenticator authenticates requests posted by Alertmanager.
+type Authenticator interface {
+	// Authenticate returns an error if the request with the given body is not authenticated.
+	Authenticate(r *http.Request, body []byte) error
+	// Challenge is the WWW-Authenticate header sent with rejected requests, if any.
+	Challenge() string
+}
+
+// BasicAuth authenticates requests by the username and password of HTTP basic authentication.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// Authenticate implements Authenticator.
+func (a BasicAuth) Authenticate(r *http.Request, _ []byte) error {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return &authError{reason: authMissing, msg: "missing basic auth credentials"}
+	}
+	// Both are compared, so the time taken does not tell whether the username was valid.
+	validUser := secureCompare(username, a.Username)
+	validPassword := secureCompare(password, a.Password)
+	if !validUser || !validPassword {
+		return &authError{reason: authInvalid, msg: "invalid basic auth credentials"}
+	}
+	return nil
+}
+
+// Challenge implements Authenticator.
+func (a BasicAuth) Challenge() string { return `Basic realm="prometheus-webexteams"` }
+
+// BearerAuth authenticates requests by the bearer token of the Authorization header.
+type BearerAuth struct {
+	Token string
+}
+
+// Authenticate implements Authenticator.
+func (a BearerAuth) Authenticate(r *http.Request, _ []byte) error {
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		return &authError{reason: authMissing, msg: "missing bearer token"}
+	}
+	if !secureCompare(token, a.Token) {
+		return &authError{reason: authInvalid, msg: "invalid bearer token"}
+	}
+	return nil
+}
+
+// Challenge implements Authenticator.
+func (a BearerAuth) Challenge() string { return `Bearer realm="prometheus-webexteams"` }
+
+// DefaultSignatureHeader is the header holding the HMAC signature if none is configured.
+const DefaultSignatureHeader = "X-Signature"
+
+// HMACAuth authenticates requests by the hex encoded HMAC-SHA256 signature of their body.
+// The signature may be prefixed with "sha256=", as sent by most webhook proxies.
+type HMACAuth struct {
+	Secret string
+	// Header holds the signature, DefaultSignatureHeader if empty.
+	Header string
+}
+
+// Authenticate implements Authenticator.
+func (a HMACAuth) Authenticate(r *http.Request, body []byte) error {
+	header := a.Header
+	if header == "" {
+		header = DefaultSignatureHeader
+	}
+	signature := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(header)), "sha256=")
+	if signature == "" {
+		return &authError{reason: authMissing, msg: "missing " + header + " signature header"}
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return &authError{reason: authInvalid, msg: "invalid " + header + " signature header"}
+	}
+	mac := hmac.New(sha256.New, []byte(a.Secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return &authError{reason: authInvalid, msg: "invalid " + header + " signature"}
+	}
+	return nil
+}
+
+// Challenge implements Authenticator.
+func (a HMACAuth) Challenge() string { return "" }
+
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
+
+func secureCompare(given, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// authReason returns the reason of a failed authentication.
+func authReason(err error) string {
+	var ae *authError
+	if errors.As(err, &ae) {
+		return ae.reason
+	}
+	return authInvalid
+}
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticators(t *testing.T) {
+	body := []byte(`{"status":"firing"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name       string
+		auth       Authenticator
+		header     map[string]string
+		wantReason string
+	}{
+		{"basic valid", BasicAuth{Username: "am", Password: "pw"}, map[string]string{"Authorization": "Basic YW06cHc="}, ""},
+		{"basic wrong password", BasicAuth{Username: "am", Password: "other"}, map[string]string{"Authorization": "Basic YW06cHc="}, authInvalid},
+		{"basic missing", BasicAuth{Username: "am", Password: "pw"}, nil, authMissing},
+		{"bearer valid", BearerAuth{Token: "token"}, map[string]string{"Authorization": "bearer token"}, ""},
+		{"bearer invalid", BearerAuth{Token: "token"}, map[string]string{"Authorization": "Bearer other"}, authInvalid},
+		{"bearer with basic auth", BearerAuth{Token: "token"}, map[string]string{"Authorization": "Basic YW06cHc="}, authMissing},
+		{"hmac valid", HMACAuth{Secret: "secret"}, map[string]string{"X-Signature": signature}, ""},
+		{"hmac prefixed in custom header", HMACAuth{Secret: "secret", Header: "X-Hub-Signature-256"}, map[string]string{"X-Hub-Signature-256": "sha256=" + signature}, ""},
+		{"hmac other secret", HMACAuth{Secret: "other"}, map[string]string{"X-Signature": signature}, authInvalid},
+		{"hmac not hex", HMACAuth{Secret: "secret"}, map[string]string{"X-Signature": "zz"}, authInvalid},
+		{"hmac missing", HMACAuth{Secret: "secret"}, nil, authMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/alertmanager", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			err := tt.auth.Authenticate(r, body)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("want authenticated, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("want the request to be rejected")
+			}
+			if got := authReason(err); got != tt.wantReason {
+				t.Errorf("want reason %s, got %s", tt.wantReason, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package transport
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// Measures and tag keys recorded by the web server.
+var (
+	// KeyRequestPath is the request path of a connector.
+	KeyRequestPath = tag.MustNewKey("request_path")
+	// KeyReason is the reason of a failed authentication, either "missing" or "invalid".
+	KeyReason = tag.MustNewKey("reason")
+
+	// MeasureAuthFailures counts requests rejected because they were not authenticated.
+	MeasureAuthFailures = stats.Int64(
+		"webexteams/auth_failures",
+		"Number of requests rejected because they were not authenticated",
+		stats.UnitDimensionless,
+	)
+)
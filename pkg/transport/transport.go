@@ -2,15 +2,20 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/infonova/prometheus-webexteams/pkg/service"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/alertmanager/notify/webhook"
 	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 
 	"github.com/labstack/echo/v4"
@@ -20,15 +25,53 @@ import (
 type Route struct {
 	Service     service.Service
 	RequestPath string
+	// Auth authenticates the requests to the route, all requests are accepted if nil.
+	Auth Authenticator
+}
+
+// Router holds the routes served by the web server.
+// The routes can be replaced at runtime, e.g. when the configuration is reloaded.
+type Router struct {
+	logger log.Logger
+	routes atomic.Value // map[string]Route
+}
+
+// NewRouter creates a Router serving the given routes.
+func NewRouter(logger log.Logger, routes ...Route) *Router {
+	r := &Router{logger: logger}
+	r.Set(routes...)
+	return r
+}
+
+// Set atomically replaces all routes of the Router.
+func (r *Router) Set(routes ...Route) {
+	m := make(map[string]Route, len(routes))
+	for _, rt := range routes {
+		level.Debug(r.logger).Log("request_path_added", rt.RequestPath, "auth", rt.Auth != nil)
+		m[NormalizePath(rt.RequestPath)] = rt
+	}
+	r.routes.Store(m)
+}
+
+func (r *Router) lookup(path string) (Route, bool) {
+	rt, ok := r.routes.Load().(map[string]Route)[NormalizePath(path)]
+	return rt, ok
+}
+
+// NormalizePath returns the request path the way it is matched by the Router.
+func NormalizePath(p string) string {
+	return "/" + strings.Trim(p, "/")
 }
 
 // NewServer creates the web server.
 func NewServer(logger log.Logger, routes ...Route) *echo.Echo {
+	return NewRouterServer(logger, NewRouter(logger, routes...))
+}
+
+// NewRouterServer creates the web server serving the routes of the given Router.
+func NewRouterServer(logger log.Logger, router *Router) *echo.Echo {
 	e := echo.New()
-	for _, r := range routes {
-		level.Debug(logger).Log("request_path_added", r.RequestPath)
-		addRoute(e, r.RequestPath, r.Service, logger)
-	}
+	addRoutes(e, router, logger)
 	e.HideBanner = true
 	return e
 }
@@ -62,8 +105,13 @@ func kitLoggerMiddleware(logger log.Logger) echo.MiddlewareFunc {
 	}
 }
 
-func addRoute(e *echo.Echo, p string, s service.Service, logger log.Logger) {
-	e.POST(p, func(c echo.Context) error {
+func addRoutes(e *echo.Echo, router *Router, logger log.Logger) {
+	e.POST("/*", func(c echo.Context) error {
+		rt, ok := router.lookup(c.Request().URL.Path)
+		if !ok {
+			return echo.ErrNotFound
+		}
+
 		ctx, span := trace.StartSpan(c.Request().Context(), "alertmanager-handler")
 		defer span.End()
 
@@ -74,6 +122,29 @@ func addRoute(e *echo.Echo, p string, s service.Service, logger log.Logger) {
 			return c.String(500, err.Error())
 		}
 
+		if rt.Auth != nil {
+			if err := rt.Auth.Authenticate(c.Request(), b); err != nil {
+				reason := authReason(err)
+				logger.Log(
+					"message", "request rejected",
+					"request_path", rt.RequestPath,
+					"remote_addr", c.RealIP(),
+					"reason", reason,
+					"err", err,
+				)
+				_ = stats.RecordWithTags(
+					ctx,
+					[]tag.Mutator{tag.Upsert(KeyRequestPath, rt.RequestPath), tag.Upsert(KeyReason, reason)},
+					MeasureAuthFailures.M(1),
+				)
+				span.SetStatus(trace.Status{Code: trace.StatusCodeUnauthenticated, Message: err.Error()})
+				if challenge := rt.Auth.Challenge(); challenge != "" {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, challenge)
+				}
+				return c.String(http.StatusUnauthorized, err.Error())
+			}
+		}
+
 		span.AddAttributes(trace.StringAttribute("alert", string(b)))
 
 		var wm webhook.Message
@@ -83,11 +154,12 @@ func addRoute(e *echo.Echo, p string, s service.Service, logger log.Logger) {
 			return c.String(500, err.Error())
 		}
 
-		prs, err := s.Post(ctx, wm)
+		prs, err := rt.Service.Post(ctx, wm)
 		if err != nil {
 			logger.Log("err", err)
-			span.SetStatus(trace.Status{Code: 500, Message: err.Error()})
-			return c.String(500, err.Error())
+			status := errorStatus(err)
+			span.SetStatus(trace.Status{Code: int32(status), Message: err.Error()})
+			return c.String(status, err.Error())
 		}
 
 		return c.JSON(200, prs)
@@ -96,3 +168,12 @@ func addRoute(e *echo.Echo, p string, s service.Service, logger log.Logger) {
 		opencensusMiddleware(),
 	)
 }
+
+// errorStatus returns the HTTP status code carried by err, or 500 if there is none.
+func errorStatus(err error) int {
+	var sc interface{ StatusCode() int }
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+	return 500
+}
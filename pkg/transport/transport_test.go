@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/infonova/prometheus-webexteams/pkg/card"
+	"github.com/infonova/prometheus-webexteams/pkg/service"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// failingService answers every message with err, it succeeds if err is nil.
+type failingService struct {
+	err error
+}
+
+func (s failingService) Post(context.Context, webhook.Message) (service.PostResponse, error) {
+	return service.PostResponse{}, s.err
+}
+
+func post(t *testing.T, e http.Handler, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestServer_errorStatus(t *testing.T) {
+	alert, err := ioutil.ReadFile("../card/testdata/prometheus_fire_request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := card.NewCardBuilder(card.DefaultBuilderConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webex := func(status int) string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(s.Close)
+		return s.URL
+	}
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	webexService := func(webhookURL string) service.Service {
+		return service.NewSimpleService(converter, http.DefaultClient, service.Options{
+			WebhookURL:  webhookURL,
+			AccessToken: "token",
+			Targets:     []service.Target{{RoomID: "room"}},
+			Retry:       service.RetryPolicy{MaxAttempts: 1},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		service    service.Service
+		wantStatus int
+	}{
+		{"webex server error", webexService(webex(http.StatusInternalServerError)), http.StatusBadGateway},
+		{"webex client error", webexService(webex(http.StatusNotFound)), http.StatusBadRequest},
+		{"webex unreachable", webexService(unreachable.URL), http.StatusServiceUnavailable},
+		{"wrapped delivery error", failingService{fmt.Errorf("room a: %w", &service.DeliveryError{Status: http.StatusTeapot, Err: errors.New("teapot")})}, http.StatusTeapot},
+		{"other error", failingService{errors.New("failed to render card")}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewServer(log.NewNopLogger(), Route{RequestPath: "alerts", Service: tt.service})
+			if w := post(t, e, "/alerts", string(alert), nil); w.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d: %s", tt.wantStatus, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestServer_auth(t *testing.T) {
+	v := &view.View{
+		Name:        "test_auth_failures",
+		Measure:     MeasureAuthFailures,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{KeyRequestPath, KeyReason},
+	}
+	if err := view.Register(v); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(v)
+
+	e := NewServer(log.NewNopLogger(), Route{
+		RequestPath: "alerts",
+		Service:     failingService{},
+		Auth:        BasicAuth{Username: "am", Password: "pw"},
+	})
+
+	w := post(t, e, "/alerts", "{}", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("want status 401 without credentials, got %d", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got == "" {
+		t.Error("want a WWW-Authenticate challenge")
+	}
+	if w := post(t, e, "/alerts", "{}", map[string]string{"Authorization": "Basic YW06b3RoZXI="}); w.Code != http.StatusUnauthorized {
+		t.Errorf("want status 401 with a wrong password, got %d", w.Code)
+	}
+	if w := post(t, e, "/alerts", "{}", map[string]string{"Authorization": "Basic YW06cHc="}); w.Code != http.StatusOK {
+		t.Errorf("want status 200 with valid credentials, got %d", w.Code)
+	}
+
+	rows, err := view.RetrieveData(v.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, r := range rows {
+		var path, reason string
+		for _, tg := range r.Tags {
+			switch tg.Key {
+			case KeyRequestPath:
+				path = tg.Value
+			case KeyReason:
+				reason = tg.Value
+			}
+		}
+		got[path+" "+reason] = r.Data.(*view.CountData).Value
+	}
+	want := map[string]int64{"alerts " + authMissing: 1, "alerts " + authInvalid: 1}
+	if len(got) != len(want) {
+		t.Errorf("want auth failures %v, got %v", want, got)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("want auth failures %v, got %v", want, got)
+			break
+		}
+	}
+}
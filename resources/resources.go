@@ -0,0 +1,45 @@
+// Package resources holds the files built into the binary.
+package resources
+
+import (
+	_ "embed" // for go:embed
+)
+
+// RequestTemplate is the default template of the Webex request sending a card,
+// defining "teams.request".
+//
+//go:embed webex-teams-request.tmpl
+var RequestTemplate string
+
+// CardTemplate is the default card template, defining "teams.card".
+//
+//go:embed default-message-card.tmpl
+var CardTemplate string
+
+// DigestCardTemplate is the default template of digest cards, defining "teams.card".
+//
+//go:embed digest-card.tmpl
+var DigestCardTemplate string
+
+// MSTeamsCardTemplate is the default template of the msteams backend, defining "teams.card"
+// as a MessageCard for Microsoft Teams incoming webhooks.
+//
+//go:embed msteams-message-card.tmpl
+var MSTeamsCardTemplate string
+
+// SlackMessageTemplate is the default template of the slack backend, defining "teams.card"
+// as a message for Slack incoming webhooks.
+//
+//go:embed slack-message.tmpl
+var SlackMessageTemplate string
+
+// WebhookBodyTemplate is the default template of the webhook backend, defining "teams.card"
+// as the alerts of the notification in JSON.
+//
+//go:embed webhook-body.tmpl
+var WebhookBodyTemplate string
+
+// AdaptiveCardSchema is the JSON schema of the adaptive cards.
+//
+//go:embed adaptive-card-schema.json
+var AdaptiveCardSchema string